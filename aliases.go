@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// fieldAlias renames the top-level input field From to To.
+type fieldAlias struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// fieldAliases is applied to every /evaluate input before validation.
+var fieldAliases []fieldAlias
+
+// loadFieldAliases reads `evaluate.fieldAliases`, a list of {from, to}
+// pairs. A list is used rather than a map because viper lower-cases map
+// keys, which would break camelCase field names.
+func loadFieldAliases() error {
+	var aliases []fieldAlias
+	if err := viper.UnmarshalKey("evaluate.fieldAliases", &aliases); err != nil {
+		return fmt.Errorf("failed to read evaluate.fieldAliases: %w", err)
+	}
+	for _, a := range aliases {
+		if a.From == "" || a.To == "" {
+			return fmt.Errorf("evaluate.fieldAliases entries need both from and to, got %+v", a)
+		}
+	}
+	fieldAliases = aliases
+	return nil
+}
+
+// applyFieldAliases returns input with aliased fields renamed. When the
+// client already sent the target field its value wins and the alias is
+// dropped.
+func applyFieldAliases(input map[string]interface{}) map[string]interface{} {
+	if len(fieldAliases) == 0 {
+		return input
+	}
+
+	out := copyMap(input)
+	for _, a := range fieldAliases {
+		v, ok := out[a.From]
+		if !ok {
+			continue
+		}
+		delete(out, a.From)
+		if _, exists := out[a.To]; !exists {
+			out[a.To] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// useFieldAliases loads aliases as `evaluate.fieldAliases` for the test.
+func useFieldAliases(t *testing.T, aliases []map[string]interface{}) {
+	t.Helper()
+	previous := fieldAliases
+	t.Cleanup(func() { fieldAliases = previous })
+	setConfig(t, "evaluate.fieldAliases", aliases)
+	if err := loadFieldAliases(); err != nil {
+		t.Fatalf("failed to load field aliases: %v", err)
+	}
+}
+
+func TestAliasedFieldsDecide(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	useFieldAliases(t, []map[string]interface{}{
+		{"from": "userRole", "to": "role"},
+		{"from": "op", "to": "action"},
+	})
+
+	if rec := postEvaluate("/evaluate", `{"userRole": "reader", "op": "read"}`); rec.Code != http.StatusOK {
+		t.Errorf("aliased input status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	// An explicitly sent target field wins over its alias.
+	if rec := postEvaluate("/evaluate", `{"userRole": "admin", "role": "guest"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("status with both fields = %d, want 403 from role", rec.Code)
+	}
+}
+
+func TestLoadFieldAliasesRejectsIncompleteEntries(t *testing.T) {
+	previous := fieldAliases
+	t.Cleanup(func() { fieldAliases = previous })
+	setConfig(t, "evaluate.fieldAliases", []map[string]interface{}{{"from": "user_id"}})
+	if err := loadFieldAliases(); err == nil {
+		t.Error("alias without a target accepted")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/spf13/viper"
+)
+
+// allowedAttributes evaluates the optional `evaluate.attributesQuery` for an
+// allowed input and returns the attributes the caller may see. A policy may
+// return a single list for everyone, or an object mapping roles to lists, in
+// which case the list for the role named by the `evaluate.roleField` input
+// field is returned; callers whose role has no entry get an empty list. ok
+// is false when no query is configured or it is undefined for input.
+func allowedAttributes(ctx context.Context, policy *loadedPolicy, input map[string]interface{}) (attributes []string, ok bool) {
+	if policy.attributesQuery == nil {
+		return nil, false
+	}
+	logger := loggerFromContext(ctx)
+
+	results, err := policy.attributesQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		logger.Warnw("Failed to evaluate attributes query", "error", err)
+		return nil, false
+	}
+	if len(results) == 0 {
+		return nil, false
+	}
+
+	value := results[0].Expressions[0].Value
+	if byRole, isMap := value.(map[string]interface{}); isMap {
+		role, _ := input[viper.GetString("evaluate.roleField")].(string)
+		value = byRole[role]
+	}
+
+	attributes = []string{}
+	values, _ := value.([]interface{})
+	for _, v := range values {
+		if attribute, ok := v.(string); ok {
+			attributes = append(attributes, attribute)
+		}
+	}
+	sort.Strings(attributes)
+	return attributes, true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// projectionPolicy exposes a different attribute set to each role.
+const projectionPolicy = accessPolicy + `
+allowed_attributes := {
+	"admin": ["amount", "customer", "iban"],
+	"reader": ["customer", "amount"],
+}
+`
+
+// evaluatedAttributes returns the attributes field of an /evaluate response.
+func evaluatedAttributes(t *testing.T, body string) ([]string, bool) {
+	t.Helper()
+	rec := postEvaluate("/evaluate", body)
+	var resp struct {
+		Attributes *[]string `json:"attributes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: response is not JSON: %v: %s", body, err, rec.Body)
+	}
+	if resp.Attributes == nil {
+		return nil, false
+	}
+	return *resp.Attributes, true
+}
+
+func TestAttributeProjectionPerRole(t *testing.T) {
+	setConfig(t, "evaluate.attributesQuery", "data.api.access.allowed_attributes")
+	setConfig(t, "evaluate.roleField", "role")
+	loadTestPolicy(t, stringLoader(projectionPolicy+"\nallow if input.role == \"auditor\"\n"))
+
+	for body, want := range map[string][]string{
+		`{"role": "admin"}`:                    {"amount", "customer", "iban"},
+		`{"role": "reader", "action": "read"}`: {"amount", "customer"},
+		// An allowed role without a projection sees nothing.
+		`{"role": "auditor"}`: {},
+	} {
+		got, ok := evaluatedAttributes(t, body)
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: attributes = %v, want %v", body, got, want)
+		}
+	}
+	if got, ok := evaluatedAttributes(t, `{"role": "guest"}`); ok {
+		t.Errorf("denied request got attributes %v", got)
+	}
+}
+
+func TestSingleAttributeListForEveryone(t *testing.T) {
+	setConfig(t, "evaluate.attributesQuery", "data.api.access.allowed_attributes")
+	loadTestPolicy(t, stringLoader(accessPolicy+"\nallowed_attributes := [\"status\", \"amount\"]\n"))
+	if got, _ := evaluatedAttributes(t, `{"role": "admin"}`); !reflect.DeepEqual(got, []string{"amount", "status"}) {
+		t.Errorf("attributes = %v, want the shared list sorted", got)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "admin"}`); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGeneratePolicyEmitsAuditEvent(t *testing.T) {
+	fake := useFakeS3(t)
+	logs := observeLogs(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/generate-policy", strings.NewReader(samplePolicyData))
+	req.Header.Set("Authorization", "Bearer admin-key")
+	rec := httptest.NewRecorder()
+	generatePolicyHandler(rec, req, sugar)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	events := logs.FilterMessage("Audit event").All()
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(events))
+	}
+	fields := events[0].ContextMap()
+
+	stored, _ := fake.object(billingPolicyKey)
+	sum := sha256.Sum256([]byte(stored))
+	token := sha256.Sum256([]byte("admin-key"))
+	want := map[string]interface{}{
+		"event":     "policy_generated",
+		"actor":     "token:" + hex.EncodeToString(token[:4]),
+		"objectKey": billingPolicyKey,
+		"sha256":    hex.EncodeToString(sum[:]),
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("audit field %s = %v, want %v", key, fields[key], value)
+		}
+	}
+	if ts, _ := fields["timestamp"].(string); ts == "" {
+		t.Error("audit event has no timestamp")
+	} else if _, err := time.Parse(time.RFC3339, ts); err != nil {
+		t.Errorf("timestamp %q is not RFC 3339: %v", ts, err)
+	}
+}
+
+func TestDryRunEmitsNoAuditEvent(t *testing.T) {
+	logs := observeLogs(t)
+	if rec := generateDryRun(samplePolicyData); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if n := logs.FilterMessage("Audit event").Len(); n != 0 {
+		t.Errorf("dry run produced %d audit events", n)
+	}
+}
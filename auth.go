@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// role is the access level granted by an API token. Higher roles include
+// the permissions of lower ones.
+type role int
+
+const (
+	roleNone role = iota
+	roleBasic
+	roleAdmin
+)
+
+// tokenRole returns the role granted by token: roleAdmin for tokens listed
+// in `auth.adminTokens`, roleBasic for `auth.tokens`, roleNone otherwise.
+// Every configured token is compared in constant time.
+func tokenRole(token string) role {
+	granted := roleNone
+	for _, t := range viper.GetStringSlice("auth.adminTokens") {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			granted = roleAdmin
+		}
+	}
+	for _, t := range viper.GetStringSlice("auth.tokens") {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 && granted < roleBasic {
+			granted = roleBasic
+		}
+	}
+	return granted
+}
+
+// authEnabled reports whether any tokens are configured. Without tokens the
+// service stays open, as before authentication was introduced.
+func authEnabled() bool {
+	return len(viper.GetStringSlice("auth.tokens")) > 0 || len(viper.GetStringSlice("auth.adminTokens")) > 0
+}
+
+// requireRole wraps next so it only runs for bearer tokens granting at least
+// the required role. Missing tokens get 401 and insufficient ones 403.
+func requireRole(required role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled() {
+			next(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		if tokenRole(token) < required {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// authStatus calls a handler guarded by required with token.
+func authStatus(required role, token string) int {
+	handler := requireRole(required, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec.Code
+}
+
+func TestRoleBasedAccess(t *testing.T) {
+	setConfig(t, "auth.tokens", []string{"basic-key"})
+	setConfig(t, "auth.adminTokens", []string{"admin-key"})
+
+	cases := []struct {
+		name     string
+		required role
+		token    string
+		want     int
+	}{
+		{"basic key evaluates", roleBasic, "basic-key", http.StatusOK},
+		{"basic key cannot generate", roleAdmin, "basic-key", http.StatusForbidden},
+		{"admin key evaluates", roleBasic, "admin-key", http.StatusOK},
+		{"admin key generates", roleAdmin, "admin-key", http.StatusOK},
+		{"unknown key", roleBasic, "other-key", http.StatusForbidden},
+		{"missing key", roleBasic, "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if got := authStatus(c.required, c.token); got != c.want {
+			t.Errorf("%s: status = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAuthDisabledWithoutTokens(t *testing.T) {
+	setConfig(t, "auth.tokens", []string{})
+	setConfig(t, "auth.adminTokens", []string{})
+	if got := authStatus(roleAdmin, ""); got != http.StatusOK {
+		t.Errorf("status = %d, want 200 with authentication disabled", got)
+	}
+}
+
+func TestRejectedRequestsNeverReachTheHandler(t *testing.T) {
+	setConfig(t, "auth.tokens", []string{"basic-key"})
+	setConfig(t, "auth.adminTokens", []string{"admin-key"})
+	called := false
+	handler := requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	for _, header := range []string{"", "Basic YWRtaW46a2V5", "Bearer ", "Bearer admin", "Bearer admin-key-2", "bearer admin-key"} {
+		req := httptest.NewRequest(http.MethodPost, "/generate-policy", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if called || rec.Code < 400 {
+			t.Errorf("Authorization %q: status %d, handler called %v", header, rec.Code, called)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Authorization %q: 401 without a Bearer challenge", header)
+		}
+	}
+}
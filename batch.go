@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// batchDecision is the outcome for one input of a batch evaluation. Denied
+// items carry the deny query's reasons when the policy provides them.
+type batchDecision struct {
+	Allow   bool     `json:"allow"`
+	Reasons []string `json:"reasons,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// batchSummary aggregates the outcomes of a batch evaluation.
+type batchSummary struct {
+	Allow      int     `json:"allow"`
+	Deny       int     `json:"deny"`
+	Error      int     `json:"error"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// batchEvaluateHandler evaluates several inputs in one call. The body is
+// either an array of inputs, answered with an array of decisions in the same
+// order, or an object mapping resource ids to inputs, answered with an
+// object mapping the same ids to their decisions. A failing input only marks
+// its own entry as errored. Batches larger than `evaluate.maxBatch` are
+// rejected with 413. With ?summary=true the response is
+// {"results": ..., "summary": ...} instead, adding outcome counts and the
+// total evaluation time.
+func batchEvaluateHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	var list []json.RawMessage
+	var keyed map[string]json.RawMessage
+	isList := bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+	var err error
+	if isList {
+		err = json.Unmarshal(body, &list)
+	} else {
+		err = json.Unmarshal(body, &keyed)
+	}
+	// null unmarshals into a nil map without error.
+	if err != nil || (!isList && keyed == nil) {
+		http.Error(w, "Batch must be a JSON array or object of inputs", http.StatusBadRequest)
+		return
+	}
+
+	if max := viper.GetInt("evaluate.maxBatch"); max > 0 && len(list)+len(keyed) > max {
+		http.Error(w, fmt.Sprintf("Batch exceeds the limit of %d inputs", max), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	policy := currentPolicy()
+	if policy == nil {
+		http.Error(w, "Policy not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !evalSlots.acquire(r.Context()) {
+		w.Header().Set("Retry-After", strconv.Itoa(viper.GetInt("evaluate.retryAfter")))
+		http.Error(w, "Too many concurrent evaluations", http.StatusTooManyRequests)
+		return
+	}
+	defer evalSlots.release()
+
+	start := time.Now()
+	var results interface{}
+	var all []batchDecision
+	if isList {
+		decisions := make([]batchDecision, len(list))
+		for i, raw := range list {
+			decisions[i] = evalBatchItem(r, logger.With("index", i), policy, raw)
+		}
+		results, all = decisions, decisions
+	} else {
+		decisions := make(map[string]batchDecision, len(keyed))
+		for id, raw := range keyed {
+			decisions[id] = evalBatchItem(r, logger.With("resource", id), policy, raw)
+			all = append(all, decisions[id])
+		}
+		results = decisions
+	}
+
+	if r.URL.Query().Get("summary") != "true" {
+		writeJSON(w, r, http.StatusOK, results)
+		return
+	}
+
+	summary := batchSummary{DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+	for _, d := range all {
+		switch {
+		case d.Error != "":
+			summary.Error++
+		case d.Allow:
+			summary.Allow++
+		default:
+			summary.Deny++
+		}
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"results": results, "summary": summary})
+}
+
+// evalBatchItem decides a single batch input. The input is prepared and the
+// decision logged exactly as for /evaluate, each item getting its own
+// decision id.
+func evalBatchItem(r *http.Request, logger *zap.SugaredLogger, policy *loadedPolicy, raw json.RawMessage) batchDecision {
+	var input map[string]interface{}
+	if err := json.Unmarshal(raw, &input); err != nil || input == nil {
+		return batchDecision{Error: "input must be a JSON object"}
+	}
+	input, err := prepareInput(r, input)
+
+	application, _ := input["applicationName"].(string)
+	decisionID := uuid.NewString()
+	logger = requestLogger(logger, r, application).With("decisionId", decisionID)
+	ctx := contextWithLogger(r.Context(), logger)
+
+	var rejected *inputError
+	if errors.As(err, &rejected) {
+		logger.Warnw("Rejected batch item input", "error", rejected)
+		return batchDecision{Error: rejected.Error()}
+	}
+	if err != nil {
+		logInternalError(logger, "Failed to validate batch item input", err)
+		return batchDecision{Error: "failed to validate input"}
+	}
+
+	allow, err := evalDecision(ctx, policy.query, input)
+	if err != nil {
+		logger.Warnw("Batch item evaluation failed", "error", err)
+		return batchDecision{Error: err.Error()}
+	}
+	recordDecision(logger, decisionID, policy.revision, allow, allow, input)
+	if allow {
+		return batchDecision{Allow: true}
+	}
+	return batchDecision{Reasons: denyReasons(ctx, policy, input)}
+}
+
+// evalDecision evaluates query for input and returns its boolean decision.
+func evalDecision(ctx context.Context, query *rego.PreparedEvalQuery, input map[string]interface{}) (bool, error) {
+	decision, err := Evaluate(ctx, query, input)
+	if err != nil {
+		return false, err
+	}
+	if !decision.Defined {
+		return false, errors.New("no result from policy evaluation")
+	}
+	allow, ok := decision.Allow()
+	if !ok {
+		return false, errors.New("policy decision is not a boolean")
+	}
+	return allow, nil
+}
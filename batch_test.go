@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reasonedPolicy is accessPolicy with a deny reason for guests.
+const reasonedPolicy = accessPolicy + `
+deny contains "guests may not access invoices" if input.role == "guest"
+`
+
+func postBatch(target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	batchEvaluateHandler(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)), sugar)
+	return rec
+}
+
+func TestBatchKeyedByResource(t *testing.T) {
+	loadTestPolicy(t, stringLoader(reasonedPolicy))
+
+	rec := postBatch("/evaluate/batch", `{
+		"invoice-1": {"role": "admin"},
+		"invoice-2": {"role": "guest"},
+		"invoice-3": "not an object"
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got map[string]batchDecision
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response is not an object of decisions: %v: %s", err, rec.Body)
+	}
+	want := map[string]batchDecision{
+		"invoice-1": {Allow: true},
+		"invoice-2": {Reasons: []string{"guests may not access invoices"}},
+		"invoice-3": {Error: "input must be a JSON object"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decisions = %+v, want %+v", got, want)
+	}
+}
+
+func TestBatchListKeepsOrderAndSummarizes(t *testing.T) {
+	loadTestPolicy(t, stringLoader(reasonedPolicy))
+
+	rec := postBatch("/evaluate/batch?summary=true", `[{"role": "guest"}, {"role": "admin"}, 7]`)
+	var got struct {
+		Results []batchDecision `json:"results"`
+		Summary batchSummary    `json:"summary"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, rec.Body)
+	}
+	if len(got.Results) != 3 || got.Results[0].Allow || !got.Results[1].Allow || got.Results[2].Error == "" {
+		t.Errorf("results = %+v, want deny, allow, error in order", got.Results)
+	}
+	if s := got.Summary; s.Allow != 1 || s.Deny != 1 || s.Error != 1 {
+		t.Errorf("summary = %+v, want one of each outcome", s)
+	}
+}
+
+func TestBatchLogsEachDecision(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "log.decisions", true)
+	logs := observeLogs(t)
+
+	postBatch("/evaluate/batch", `[{"role": "admin"}, {"role": "guest"}]`)
+	entries := logs.FilterMessage("Decision").All()
+	if len(entries) != 2 {
+		t.Fatalf("got %d decision log lines, want one per item", len(entries))
+	}
+	first, second := entries[0].ContextMap(), entries[1].ContextMap()
+	if first["decisionId"] == "" || first["decisionId"] == second["decisionId"] {
+		t.Errorf("decision ids %v and %v, want a distinct id per item", first["decisionId"], second["decisionId"])
+	}
+	if first["allow"] != true || second["allow"] != false {
+		t.Errorf("logged decisions = %v, %v, want allow then deny", first["allow"], second["allow"])
+	}
+}
+
+func TestBatchRejectsOversizedBatch(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.maxBatch", 2)
+	if rec := postBatch("/evaluate/batch", `[{}, {}, {}]`); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestBatchRejectsNonBatchBodies(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	for _, body := range []string{`null`, ``, `1`, `"inputs"`} {
+		if rec := postBatch("/evaluate/batch", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("body %q: status = %d, want 400: %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestBatchSummaryMatchesKeyedResults(t *testing.T) {
+	loadTestPolicy(t, stringLoader(reasonedPolicy))
+
+	rec := postBatch("/evaluate/batch?summary=true", `{
+		"a": {"role": "admin"},
+		"b": {"role": "reader", "action": "read"},
+		"c": {"role": "guest"},
+		"d": [],
+		"e": {"role": "reader", "action": "write"}
+	}`)
+	var got struct {
+		Results map[string]batchDecision `json:"results"`
+		Summary batchSummary             `json:"summary"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, rec.Body)
+	}
+	var want batchSummary
+	for _, d := range got.Results {
+		switch {
+		case d.Error != "":
+			want.Error++
+		case d.Allow:
+			want.Allow++
+		default:
+			want.Deny++
+		}
+	}
+	if s := got.Summary; s.Allow != 2 || s.Deny != 2 || s.Error != 1 || s.Allow != want.Allow || s.Deny != want.Deny || s.Error != want.Error {
+		t.Errorf("summary = %+v, results count %+v, want 2 allow, 2 deny, 1 error", s, want)
+	}
+	if got.Summary.DurationMs <= 0 {
+		t.Errorf("summary duration = %vms, want the batch's evaluation time", got.Summary.DurationMs)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/open-policy-agent/opa/ast"
+	"go.uber.org/zap"
+)
+
+// builtinsHandler reports the builtin functions a policy calls, so reviewers
+// can spot sensitive ones such as http.send or opa.runtime. GET analyzes the
+// loaded modules; POST analyzes the Rego module sent as the request body.
+func builtinsHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	modules := map[string]string{}
+	switch r.Method {
+	case "GET":
+		if policy := policies.Get(); policy != nil {
+			modules = policy.modules
+		}
+	case "POST":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		modules["request.rego"] = string(body)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var parsed []*ast.Module
+	for name, src := range modules {
+		module, err := ast.ParseModule(name, src)
+		if err != nil {
+			writeJSON(w, r, http.StatusBadRequest, map[string]interface{}{"errors": compileErrors(err)})
+			return
+		}
+		parsed = append(parsed, module)
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"builtins": referencedBuiltins(parsed)})
+}
+
+// referencedBuiltins returns the sorted names of builtins called by modules.
+// Infix operators such as == and := are left out.
+func referencedBuiltins(modules []*ast.Module) []string {
+	seen := map[string]bool{}
+	record := func(operator ast.Ref) {
+		name := operator.String()
+		if b, ok := ast.BuiltinMap[name]; ok && b.Infix == "" {
+			seen[name] = true
+		}
+	}
+
+	for _, module := range modules {
+		ast.WalkExprs(module, func(expr *ast.Expr) bool {
+			if expr.IsCall() {
+				record(expr.Operator())
+			}
+			return false
+		})
+		ast.WalkTerms(module, func(term *ast.Term) bool {
+			if call, ok := term.Value.(ast.Call); ok {
+				if operator, ok := call[0].Value.(ast.Ref); ok {
+					record(operator)
+				}
+			}
+			return false
+		})
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func builtinsRequest(method, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	builtinsHandler(rec, httptest.NewRequest(method, "/builtins", strings.NewReader(body)), sugar)
+	return rec
+}
+
+func TestBuiltinsReportsHTTPSend(t *testing.T) {
+	src := `package api.access
+
+import rego.v1
+
+profile := http.send({"method": "GET", "url": input.profileURL}).body
+
+allow if {
+	count(profile.groups) > 0
+	startswith(input.path, "/public")
+}
+`
+	rec := builtinsRequest(http.MethodPost, src)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Builtins []string `json:"builtins"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	if want := []string{"count", "http.send", "startswith"}; !reflect.DeepEqual(body.Builtins, want) {
+		t.Errorf("builtins = %v, want %v", body.Builtins, want)
+	}
+}
+
+func TestBuiltinsOfLoadedPolicy(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	rec := builtinsRequest(http.MethodGet, "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"builtins":[]}` {
+		t.Errorf("got %d %s, want no builtins for a policy using only operators", rec.Code, rec.Body)
+	}
+}
+
+func TestBuiltinsRejectsUnparsablePolicy(t *testing.T) {
+	if rec := builtinsRequest(http.MethodPost, "package"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
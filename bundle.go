@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"go.uber.org/zap"
+)
+
+// exportBundleHandler packages the currently loaded policy modules and data
+// into a gzipped OPA bundle so it can be served by a standalone OPA.
+func exportBundleHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "GET" {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	policy := policies.Get()
+	if policy == nil || len(policy.modules) == 0 {
+		http.Error(w, "No policy loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	b := currentBundle(policy.modules, policy.data)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="bundle.tar.gz"`)
+	if err := bundle.NewWriter(w).Write(b); err != nil {
+		// Headers are already sent, so the client only sees a truncated body.
+		logInternalError(logger, "Failed to write bundle", err)
+	}
+}
+
+// currentBundle builds a bundle from modules and data. Modules are sorted by
+// name so repeated exports produce identical archives.
+func currentBundle(modules map[string]string, data map[string]interface{}) bundle.Bundle {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := bundle.Bundle{
+		Data: data,
+	}
+	for _, name := range names {
+		b.Modules = append(b.Modules, bundle.ModuleFile{
+			URL:  "/" + name,
+			Path: "/" + name,
+			Raw:  []byte(modules[name]),
+		})
+	}
+	return b
+}
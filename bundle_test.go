@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+func TestExportBundle(t *testing.T) {
+	loadTestPolicy(t, bundleStub{
+		modules: map[string]string{"access.rego": accessPolicy},
+		data:    map[string]interface{}{"roles": map[string]interface{}{"alice": "admin"}},
+	})
+
+	rec := httptest.NewRecorder()
+	exportBundleHandler(rec, httptest.NewRequest(http.MethodGet, "/export-bundle", nil), sugar)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/gzip" {
+		t.Errorf("Content-Type = %q, want application/gzip", got)
+	}
+
+	b, err := bundle.NewReader(rec.Body).Read()
+	if err != nil {
+		t.Fatalf("failed to read exported bundle: %v", err)
+	}
+	if len(b.Modules) != 1 || b.Modules[0].Path != "/access.rego" || string(b.Modules[0].Raw) != accessPolicy {
+		t.Errorf("modules = %+v, want /access.rego with the loaded source", b.Modules)
+	}
+	roles, _ := b.Data["roles"].(map[string]interface{})
+	if roles["alice"] != "admin" {
+		t.Errorf("data = %v, want roles.alice = admin", b.Data)
+	}
+}
+
+func TestExportBundleWithoutPolicy(t *testing.T) {
+	previous := policies.Get()
+	policies.Set(nil)
+	t.Cleanup(func() { policies.Set(previous) })
+
+	rec := httptest.NewRecorder()
+	exportBundleHandler(rec, httptest.NewRequest(http.MethodGet, "/export-bundle", nil), sugar)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// checkBundleLimits rejects policy sets with more modules than
+// `policy.maxModules` or data documents larger than `policy.maxDataBytes`
+// before they are compiled into memory. Zero disables a limit.
+func checkBundleLimits(modules map[string]string, dataSize int) error {
+	return checkBundleSize(len(modules), int64(dataSize))
+}
+
+// checkBundleSize applies the checkBundleLimits limits to counts known
+// before anything is downloaded, such as from an object listing.
+func checkBundleSize(moduleCount int, dataSize int64) error {
+	if max := viper.GetInt("policy.maxModules"); max > 0 && moduleCount > max {
+		return fmt.Errorf("bundle has %d modules, exceeding the limit of %d", moduleCount, max)
+	}
+	if max := viper.GetInt64("policy.maxDataBytes"); max > 0 && dataSize > max {
+		return fmt.Errorf("bundle data is %d bytes, exceeding the limit of %d", dataSize, max)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLoadRejectsBundleOverModuleLimit(t *testing.T) {
+	setConfig(t, "policy.maxModules", 2)
+	modules := map[string]string{}
+	for i := 0; i < 3; i++ {
+		modules[fmt.Sprintf("p%d.rego", i)] = fmt.Sprintf("package api.p%d\n", i)
+	}
+	modules["access.rego"] = accessPolicy
+
+	err := loadAndPreparePolicy(context.Background(), bundleStub{modules: modules})
+	if err == nil || !strings.Contains(err.Error(), "exceeding the limit of 2") {
+		t.Errorf("loading 4 modules returned %v, want the module limit error", err)
+	}
+}
+
+func TestS3BundleLimitsCheckedBeforeDownload(t *testing.T) {
+	fake := useFakeS3(t)
+	for i := 0; i < 3; i++ {
+		fake.put(fmt.Sprintf("bundle/p%d.rego", i), fmt.Sprintf("package api.p%d\n", i))
+	}
+	fake.put("bundle/roles/data.json", `{"alice": "admin"}`)
+	loader := s3PrefixLoader{prefix: "bundle/"}
+
+	setConfig(t, "policy.maxModules", 2)
+	if _, err := loader.LoadBundle(context.Background()); err == nil || !strings.Contains(err.Error(), "3 modules") {
+		t.Errorf("LoadBundle() = %v, want the module limit error", err)
+	}
+	setConfig(t, "policy.maxModules", 0)
+	setConfig(t, "policy.maxDataBytes", 10)
+	if _, err := loader.LoadBundle(context.Background()); err == nil || !strings.Contains(err.Error(), "bundle data is 18 bytes") {
+		t.Errorf("LoadBundle() = %v, want the data size error", err)
+	}
+	if n := fake.downloads(); n != 0 {
+		t.Errorf("oversized bundles were downloaded: %d objects fetched", n)
+	}
+
+	setConfig(t, "policy.maxDataBytes", 0)
+	set, err := loader.LoadBundle(context.Background())
+	if err != nil {
+		t.Fatalf("LoadBundle() within limits: %v", err)
+	}
+	if len(set.modules) != 3 || set.modules["p0.rego"] == "" {
+		t.Errorf("modules = %v, want p0.rego to p2.rego", set.modules)
+	}
+	roles, _ := set.data["roles"].(map[string]interface{})
+	if roles["alice"] != "admin" {
+		t.Errorf("data = %v, want roles.alice = admin", set.data)
+	}
+}
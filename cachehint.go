@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/spf13/viper"
+)
+
+// decisionCacheTTL evaluates the optional `evaluate.cacheTTLQuery`, which
+// lets a policy say for how many seconds its decision for input may be
+// cached. The result is capped at `evaluate.maxCacheTTL` seconds. ok is
+// false when no query is configured, it is undefined, or it does not return
+// a non-negative number.
+func decisionCacheTTL(ctx context.Context, policy *loadedPolicy, input map[string]interface{}) (seconds int, ok bool) {
+	if policy.cacheTTLQuery == nil {
+		return 0, false
+	}
+	logger := loggerFromContext(ctx)
+
+	results, err := policy.cacheTTLQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		logger.Warnw("Failed to evaluate cache TTL query", "error", err)
+		return 0, false
+	}
+	if len(results) == 0 {
+		return 0, false
+	}
+
+	var ttl float64
+	switch v := results[0].Expressions[0].Value.(type) {
+	case json.Number:
+		ttl, err = v.Float64()
+	case float64:
+		ttl = v
+	default:
+		err = fmt.Errorf("unexpected type %T", v)
+	}
+	if err != nil || ttl < 0 {
+		logger.Warnw("Cache TTL query did not return a non-negative number", "value", results[0].Expressions[0].Value)
+		return 0, false
+	}
+
+	seconds = int(ttl)
+	if max := viper.GetInt("evaluate.maxCacheTTL"); seconds > max {
+		seconds = max
+	}
+	return seconds, true
+}
+
+// setEdgeCacheHint lets shared caches such as CDNs keep an allow decision
+// for `evaluate.edgeCacheTTL` seconds while browsers revalidate every time.
+// Decisions depend on the caller and the encoding, so caches are told to
+// key on Authorization and Accept. A hint the policy already set, private
+// or no-store, is stricter and is kept. Nothing is done when the TTL is 0.
+// A shared cache cannot check request signatures or client certificates,
+// so while either is required decisions are only marked private.
+func setEdgeCacheHint(w http.ResponseWriter) {
+	seconds := viper.GetInt("evaluate.edgeCacheTTL")
+	if seconds <= 0 {
+		return
+	}
+	if viper.GetString("evaluate.hmacSecret") != "" || viper.GetString("server.tls.clientCAFile") != "" {
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		return
+	}
+	w.Header().Set("Vary", "Authorization, Accept")
+	if w.Header().Get("Cache-Control") != "" {
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=0, s-maxage=%d", seconds))
+}
+
+// setCacheHint sets Cache-Control from the policy's cache TTL for input, if
+// it provides one.
+func setCacheHint(ctx context.Context, w http.ResponseWriter, policy *loadedPolicy, input map[string]interface{}) {
+	seconds, ok := decisionCacheTTL(ctx, policy, input)
+	if !ok {
+		return
+	}
+	if seconds == 0 {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", seconds))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// ttlPolicy is accessPolicy with a cache TTL that depends on the role.
+const ttlPolicy = accessPolicy + `
+cache_ttl := 60 if input.role == "admin"
+
+cache_ttl := 3600 if input.role == "reader"
+
+cache_ttl := 0 if input.role == "guest"
+`
+
+func TestPolicyCacheTTLSetsCacheControl(t *testing.T) {
+	setConfig(t, "evaluate.cacheTTLQuery", "data.api.access.cache_ttl")
+	setConfig(t, "evaluate.maxCacheTTL", 300)
+	loadTestPolicy(t, stringLoader(ttlPolicy))
+
+	for body, want := range map[string]string{
+		`{"role": "admin"}`:                    "private, max-age=60",
+		`{"role": "reader", "action": "read"}`: "private, max-age=300",
+		`{"role": "guest"}`:                    "no-store",
+		`{"role": "auditor"}`:                  "",
+	} {
+		if got := postEvaluate("/evaluate", body).Header().Get("Cache-Control"); got != want {
+			t.Errorf("%s: Cache-Control = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestNonNumericCacheTTLIsIgnored(t *testing.T) {
+	setConfig(t, "evaluate.cacheTTLQuery", "data.api.access.cache_ttl")
+	loadTestPolicy(t, stringLoader(accessPolicy+"\ncache_ttl := \"forever\"\n"))
+	if got := postEvaluate("/evaluate", `{"role": "admin"}`).Header().Get("Cache-Control"); got != "" {
+		t.Errorf("Cache-Control = %q for a string TTL, want none", got)
+	}
+}
+
+// getEvaluate sends input to /evaluate as a GET query parameter.
+func getEvaluate(input string) *httptest.ResponseRecorder {
+	return evaluateRequest(httptest.NewRequest(http.MethodGet, "/evaluate?input="+url.QueryEscape(input), nil))
+}
+
+func TestGetEvaluateEdgeCacheHeaders(t *testing.T) {
+	setConfig(t, "evaluate.edgeCacheTTL", 30)
+	setConfig(t, "evaluate.cacheTTLQuery", "")
+	loadTestPolicy(t, stringLoader(accessPolicy))
+
+	rec := getEvaluate(`{"role": "admin"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=0, s-maxage=30" {
+		t.Errorf("Cache-Control = %q, want a 30s shared cache TTL", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Authorization, Accept" {
+		t.Errorf("Vary = %q, want Authorization and Accept", got)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET decision has no ETag")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/evaluate?input="+url.QueryEscape(`{"role": "admin"}`), nil)
+	req.Header.Set("If-None-Match", etag)
+	if rec := evaluateRequest(req); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", rec.Code)
+	}
+
+	// Denials and POSTs are never cached at the edge.
+	if got := getEvaluate(`{"role": "guest"}`).Header().Get("Cache-Control"); got != "" {
+		t.Errorf("denied GET Cache-Control = %q, want none", got)
+	}
+	if got := postEvaluate("/evaluate", `{"role": "admin"}`).Header().Get("Cache-Control"); got != "" {
+		t.Errorf("POST Cache-Control = %q, want none", got)
+	}
+}
+
+func TestPolicyCacheHintOverridesEdgeTTL(t *testing.T) {
+	setConfig(t, "evaluate.edgeCacheTTL", 30)
+	setConfig(t, "evaluate.cacheTTLQuery", "data.api.access.cache_ttl")
+	loadTestPolicy(t, stringLoader(ttlPolicy))
+	if got := getEvaluate(`{"role": "admin"}`).Header().Get("Cache-Control"); got != "private, max-age=60" {
+		t.Errorf("Cache-Control = %q, want the policy's private hint", got)
+	}
+}
+
+func TestNoEdgeCachingWhileRequestsAreAuthenticated(t *testing.T) {
+	setConfig(t, "evaluate.edgeCacheTTL", 30)
+	setConfig(t, "evaluate.cacheTTLQuery", "")
+	loadTestPolicy(t, stringLoader(accessPolicy))
+
+	for _, key := range []string{"evaluate.hmacSecret", "server.tls.clientCAFile"} {
+		t.Run(key, func(t *testing.T) {
+			setConfig(t, key, "set")
+			rec := getEvaluate(`{"role": "admin"}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "private" {
+				t.Errorf("Cache-Control = %q, want private", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// fieldType declares the type input field Field is coerced to before
+// evaluation. Field is dot-separated for nested fields.
+type fieldType struct {
+	Field string `mapstructure:"field"`
+	Type  string `mapstructure:"type"`
+}
+
+// inputTypes is applied to every /evaluate input after aliasing.
+var inputTypes []fieldType
+
+// loadInputTypes reads `evaluate.inputTypes`, a list of {field, type}
+// pairs with type one of "bool", "number" or "string". Like the aliases it
+// is a list so camelCase field names survive viper.
+func loadInputTypes() error {
+	var types []fieldType
+	if err := viper.UnmarshalKey("evaluate.inputTypes", &types); err != nil {
+		return fmt.Errorf("failed to read evaluate.inputTypes: %w", err)
+	}
+	for _, t := range types {
+		switch t.Type {
+		case "bool", "number", "string":
+		default:
+			return fmt.Errorf("evaluate.inputTypes entry %q has unsupported type %q", t.Field, t.Type)
+		}
+		if t.Field == "" {
+			return fmt.Errorf("evaluate.inputTypes entries need a field, got %+v", t)
+		}
+	}
+	inputTypes = types
+	return nil
+}
+
+// coerceInput returns a copy of input with the declared fields converted to
+// their types. Missing fields are ignored; values that cannot be converted
+// are reported as an error.
+func coerceInput(input map[string]interface{}) (map[string]interface{}, error) {
+	if len(inputTypes) == 0 {
+		return input, nil
+	}
+
+	out := copyMap(input)
+	for _, t := range inputTypes {
+		if err := coercePath(out, strings.Split(t.Field, "."), t.Type); err != nil {
+			return nil, fmt.Errorf("input field %s: %w", t.Field, err)
+		}
+	}
+	return out, nil
+}
+
+func coercePath(m map[string]interface{}, path []string, typ string) error {
+	v, ok := m[path[0]]
+	if !ok {
+		return nil
+	}
+	if len(path) == 1 {
+		coerced, err := coerceValue(v, typ)
+		if err != nil {
+			return err
+		}
+		m[path[0]] = coerced
+		return nil
+	}
+	if child, ok := v.(map[string]interface{}); ok {
+		child = copyMap(child)
+		m[path[0]] = child
+		return coercePath(child, path[1:], typ)
+	}
+	return nil
+}
+
+func coerceValue(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "bool":
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			b, err := strconv.ParseBool(x)
+			if err != nil {
+				return nil, fmt.Errorf("cannot convert %q to bool", x)
+			}
+			return b, nil
+		}
+	case "number":
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case string:
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot convert %q to number", x)
+			}
+			return f, nil
+		}
+	case "string":
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case bool, float64:
+			return fmt.Sprint(x), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %T to %s", v, typ)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// useInputTypes loads types as `evaluate.inputTypes` for the test.
+func useInputTypes(t *testing.T, types []map[string]interface{}) {
+	t.Helper()
+	previous := inputTypes
+	t.Cleanup(func() { inputTypes = previous })
+	setConfig(t, "evaluate.inputTypes", types)
+	if err := loadInputTypes(); err != nil {
+		t.Fatalf("failed to load input types: %v", err)
+	}
+}
+
+const activePolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if {
+	input.user.active == true
+	input.level >= 3
+}
+`
+
+func TestInputTypesCoerceBeforeEvaluation(t *testing.T) {
+	loadTestPolicy(t, stringLoader(activePolicy))
+	body := `{"user": {"active": "true"}, "level": "5"}`
+	if rec := postEvaluate("/evaluate", body); rec.Code != http.StatusForbidden {
+		t.Fatalf("uncoerced status = %d, want 403", rec.Code)
+	}
+
+	useInputTypes(t, []map[string]interface{}{
+		{"field": "user.active", "type": "bool"},
+		{"field": "level", "type": "number"},
+	})
+	if rec := postEvaluate("/evaluate", body); rec.Code != http.StatusOK {
+		t.Errorf("coerced status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := postEvaluate("/evaluate", `{"user": {"active": "yes"}, "level": 5}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unconvertible value status = %d, want 400", rec.Code)
+	}
+}
+
+func TestLoadInputTypesRejectsUnknownTypes(t *testing.T) {
+	previous := inputTypes
+	t.Cleanup(func() { inputTypes = previous })
+	setConfig(t, "evaluate.inputTypes", []map[string]interface{}{{"field": "active", "type": "boolean"}})
+	if err := loadInputTypes(); err == nil {
+		t.Error("unsupported type accepted")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "net/http"
+
+// In OPA compatibility mode (`evaluate.opaCompat`) /evaluate speaks the
+// format of OPA's POST /v1/data/{path} API: the request wraps the input as
+// {"input": {...}} and the response is always 200 with {"result": value},
+// the result key being absent when the query is undefined.
+
+// opaCompatInput extracts the input from an OPA Data API request body.
+func opaCompatInput(body map[string]interface{}) map[string]interface{} {
+	if input, ok := body["input"].(map[string]interface{}); ok {
+		return input
+	}
+	return map[string]interface{}{}
+}
+
+// writeOPAResult writes decision in the OPA Data API response envelope.
+func writeOPAResult(w http.ResponseWriter, r *http.Request, decision Decision, decisionID string) {
+	resp := map[string]interface{}{"decision_id": decisionID}
+	if decision.Defined {
+		resp["result"] = decision.Result
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
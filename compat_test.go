@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/open-policy-agent/opa/server/types"
+)
+
+// decodeOPAResponse decodes body as OPA's POST /v1/data response, failing on
+// any field OPA would not send.
+func decodeOPAResponse(t *testing.T, body []byte) types.DataResponseV1 {
+	t.Helper()
+	var resp types.DataResponseV1
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&resp); err != nil {
+		t.Fatalf("response %s is not an OPA Data API response: %v", body, err)
+	}
+	return resp
+}
+
+func TestOPACompatEnvelope(t *testing.T) {
+	setConfig(t, "evaluate.opaCompat", true)
+	loadTestPolicy(t, stringLoader("package api.access\n\nimport rego.v1\n\nallow if input.role == \"admin\"\n"))
+
+	rec := postEvaluate("/evaluate", `{"input": {"role": "admin"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	resp := decodeOPAResponse(t, rec.Body.Bytes())
+	if resp.Result == nil || *resp.Result != true {
+		t.Errorf("result = %v, want true", resp.Result)
+	}
+	if resp.DecisionID == "" || resp.DecisionID != rec.Header().Get("X-Decision-ID") {
+		t.Errorf("decision_id = %q, want the X-Decision-ID header", resp.DecisionID)
+	}
+
+	// Like OPA, an undefined decision is still 200 and omits result.
+	rec = postEvaluate("/evaluate", `{"input": {"role": "guest"}}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("undefined decision status = %d, want 200", rec.Code)
+	}
+	if resp := decodeOPAResponse(t, rec.Body.Bytes()); resp.Result != nil {
+		t.Errorf("undefined decision result = %v, want it omitted", *resp.Result)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/spf13/viper"
+)
+
+// compileError is a single parse or compile error reported by OPA, with the
+// location it refers to in the policy source.
+type compileError struct {
+	File    string `json:"file"`
+	Row     int    `json:"row"`
+	Col     int    `json:"col"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// allowQuery returns `policy.query`, the query whose boolean result decides
+// /evaluate requests.
+func allowQuery() string {
+	return viper.GetString("policy.query")
+}
+
+// compilePolicy compiles a single Rego module and prepares the allow query.
+func compilePolicy(ctx context.Context, moduleName, policyString string) (rego.PreparedEvalQuery, error) {
+	return prepareQuery(ctx, allowQuery(), map[string]string{moduleName: policyString})
+}
+
+// checkQueryDefined returns an error when query does not refer to any rule
+// in modules. Such a query prepares fine but is undefined for every input,
+// which usually means it does not match the policy's package.
+func checkQueryDefined(query string, modules map[string]string) error {
+	ref, err := ast.ParseRef(query)
+	if err != nil {
+		return fmt.Errorf("invalid query %q: %w", query, err)
+	}
+	compiler, err := ast.CompileModules(modules)
+	if err != nil {
+		return err
+	}
+	if len(compiler.GetRules(ref)) == 0 {
+		return fmt.Errorf("query %q does not reference any rule in the loaded policy", query)
+	}
+	return nil
+}
+
+// prepareQuery compiles modules and prepares query against them. When
+// `policy.strict` is set the compiler runs in strict mode, rejecting unused
+// variables, duplicate imports and other likely mistakes. Queries read data
+// from dataStore unless an extra option supplies another store; extra
+// options are applied after the query and modules.
+func prepareQuery(ctx context.Context, query string, modules map[string]string, extra ...func(*rego.Rego)) (rego.PreparedEvalQuery, error) {
+	opts := []func(*rego.Rego){
+		rego.Query(query),
+		rego.Strict(viper.GetBool("policy.strict")),
+		rego.Store(dataStore),
+	}
+	for name, src := range modules {
+		opts = append(opts, rego.Module(name, src))
+	}
+	opts = append(opts, extra...)
+	return rego.New(opts...).PrepareForEval(ctx)
+}
+
+// prepareOptionalQuery prepares the query configured under key against
+// modules, returning nil when the key is empty.
+func prepareOptionalQuery(ctx context.Context, key string, modules map[string]string, extra ...func(*rego.Rego)) (*rego.PreparedEvalQuery, error) {
+	query := viper.GetString(key)
+	if query == "" {
+		return nil, nil
+	}
+	prepared, err := prepareQuery(ctx, query, modules, extra...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare %s %q: %w", key, query, err)
+	}
+	return &prepared, nil
+}
+
+// prepareAuxiliaryQueries prepares the optional and selectable queries
+// configured for policy's modules and stores them on policy. Extra options,
+// such as another store, apply to every query.
+func prepareAuxiliaryQueries(ctx context.Context, policy *loadedPolicy, extra ...func(*rego.Rego)) error {
+	optional := []struct {
+		key   string
+		query **rego.PreparedEvalQuery
+	}{
+		{"evaluate.scopesQuery", &policy.scopesQuery},
+		{"evaluate.reasonQuery", &policy.reasonQuery},
+		{"evaluate.denialCategoryQuery", &policy.denialCategoryQuery},
+		{"evaluate.denyQuery", &policy.denyQuery},
+		{"evaluate.attributesQuery", &policy.attributesQuery},
+		{"evaluate.notApplicableQuery", &policy.notApplicableQuery},
+		{"evaluate.cacheTTLQuery", &policy.cacheTTLQuery},
+	}
+	for _, o := range optional {
+		prepared, err := prepareOptionalQuery(ctx, o.key, policy.modules, extra...)
+		if err != nil {
+			return err
+		}
+		*o.query = prepared
+	}
+
+	policy.selectable = map[string]*rego.PreparedEvalQuery{}
+	for _, query := range viper.GetStringSlice("evaluate.allowedQueries") {
+		prepared, err := prepareQuery(ctx, query, policy.modules, extra...)
+		if err != nil {
+			return fmt.Errorf("failed to prepare allowed query %q: %w", query, err)
+		}
+		policy.selectable[query] = &prepared
+	}
+	return nil
+}
+
+// compileErrors flattens the error returned by PrepareForEval into a list of
+// located errors. Parse errors come back as rego.Errors and compile errors as
+// ast.Errors; anything else is reported as a single error without a location.
+func compileErrors(err error) []compileError {
+	if err == nil {
+		return nil
+	}
+
+	var astErr *ast.Error
+	var astErrs ast.Errors
+	var regoErrs rego.Errors
+	switch {
+	case errors.As(err, &astErrs):
+		var out []compileError
+		for _, e := range astErrs {
+			out = append(out, compileErrors(e)...)
+		}
+		return out
+	case errors.As(err, &regoErrs):
+		var out []compileError
+		for _, e := range regoErrs {
+			out = append(out, compileErrors(e)...)
+		}
+		return out
+	case errors.As(err, &astErr):
+		ce := compileError{Code: astErr.Code, Message: astErr.Message}
+		if astErr.Location != nil {
+			ce.File = astErr.Location.File
+			ce.Row = astErr.Location.Row
+			ce.Col = astErr.Location.Col
+		}
+		return []compileError{ce}
+	}
+	return []compileError{{Message: err.Error()}}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompileErrorsAreLocated(t *testing.T) {
+	src := `package api.access
+
+import rego.v1
+
+allow if {
+	input.role == unknown_one
+}
+
+deny if {
+	input.role == unknown_two
+}
+`
+	_, err := compilePolicy(context.Background(), "broken.rego", src)
+	if err == nil {
+		t.Fatal("expected compile error")
+	}
+
+	errs := compileErrors(err)
+	if len(errs) != 2 {
+		t.Fatalf("got %d errors, want 2: %+v", len(errs), errs)
+	}
+	rows := map[int]bool{}
+	for _, e := range errs {
+		if e.File != "broken.rego" || e.Code == "" || e.Message == "" || e.Col == 0 {
+			t.Errorf("error lacks location or code: %+v", e)
+		}
+		rows[e.Row] = true
+	}
+	if !rows[6] || !rows[10] {
+		t.Errorf("error rows = %v, want 6 and 10", rows)
+	}
+}
+
+func TestCompileErrorsForParseErrors(t *testing.T) {
+	_, err := compilePolicy(context.Background(), "syntax.rego", "package api.access\n\nallow if {\n")
+	errs := compileErrors(err)
+	if len(errs) == 0 || errs[0].File != "syntax.rego" || errs[0].Row == 0 {
+		t.Errorf("parse error not located: %+v", errs)
+	}
+}
+
+func TestCheckQueryDefined(t *testing.T) {
+	modules := map[string]string{"policy.rego": accessPolicy}
+	if err := checkQueryDefined("data.api.access.allow", modules); err != nil {
+		t.Errorf("defined query rejected: %v", err)
+	}
+	if err := checkQueryDefined("data.other.allow", modules); err == nil {
+		t.Error("query outside the policy's package accepted")
+	}
+}
+
+func TestStrictModeRejectsUnusedVariables(t *testing.T) {
+	// The unused local x compiles normally but fails strict mode.
+	src := `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if {
+	x := input.role
+	input.role == "admin"
+}
+`
+	setConfig(t, "policy.strict", false)
+	if _, err := compilePolicy(context.Background(), "lenient.rego", src); err != nil {
+		t.Fatalf("policy rejected outside strict mode: %v", err)
+	}
+
+	setConfig(t, "policy.strict", true)
+	_, err := compilePolicy(context.Background(), "lenient.rego", src)
+	if err == nil {
+		t.Fatal("policy with an unused variable accepted in strict mode")
+	}
+	errs := compileErrors(err)
+	if len(errs) != 1 || errs[0].Row != 8 {
+		t.Errorf("errors = %+v, want one on row 8", errs)
+	}
+	if err := loadAndPreparePolicy(context.Background(), stringLoader(src)); err == nil {
+		t.Error("strict mode not applied when loading")
+	}
+}
+
+func TestConfiguredModuleNameInCompileErrors(t *testing.T) {
+	setConfig(t, "policy.moduleName", "billing/access.rego")
+	broken := "package api.access\n\nimport rego.v1\n\nallow if input.role == missing_role\n"
+
+	err := loadAndPreparePolicy(context.Background(), stringLoader(broken))
+	if err == nil {
+		t.Fatal("broken policy loaded")
+	}
+	if !strings.Contains(err.Error(), "billing/access.rego:5") {
+		t.Errorf("error %q does not name the configured module", err)
+	}
+	if errs := compileErrors(err); len(errs) != 1 || errs[0].File != "billing/access.rego" {
+		t.Errorf("structured errors = %+v, want file billing/access.rego", errs)
+	}
+}
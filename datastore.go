@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// dataStore holds the data documents policies are evaluated against. It is
+// shared by every prepared query, so rewriting it takes effect on the next
+// evaluation without recompiling.
+var dataStore = inmem.New()
+
+// dataGeneration counts writes to dataStore, so cached decisions can tell
+// when the data behind them changed without the policy changing.
+var dataGeneration atomic.Uint64
+
+// bundleDataKeys remembers the top-level data keys written by the last
+// policy load, so documents dropped from the bundle are removed again.
+var bundleDataKeys struct {
+	sync.Mutex
+	keys []string
+}
+
+// replaceBundleData swaps the data documents loaded with the policy for data
+// in a single transaction. Top-level keys owned by other sources, such as
+// the DynamoDB mirror, are left alone unless the bundle defines them too.
+func replaceBundleData(ctx context.Context, data map[string]interface{}) error {
+	bundleDataKeys.Lock()
+	defer bundleDataKeys.Unlock()
+
+	err := storage.Txn(ctx, dataStore, storage.WriteParams, func(txn storage.Transaction) error {
+		for _, key := range bundleDataKeys.keys {
+			if _, ok := data[key]; ok {
+				continue
+			}
+			if err := dataStore.Write(ctx, txn, storage.RemoveOp, storage.Path{key}, nil); err != nil && !storage.IsNotFound(err) {
+				return err
+			}
+		}
+		for key, value := range data {
+			if err := dataStore.Write(ctx, txn, storage.AddOp, storage.Path{key}, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	dataGeneration.Add(1)
+
+	bundleDataKeys.keys = bundleDataKeys.keys[:0]
+	for key := range data {
+		bundleDataKeys.keys = append(bundleDataKeys.keys, key)
+	}
+	return nil
+}
+
+// dataSnapshot returns a deep copy of dataStore as it would be after
+// replaceBundleData(ctx, bundleData), leaving the store itself untouched.
+func dataSnapshot(ctx context.Context, bundleData map[string]interface{}) (map[string]interface{}, error) {
+	bundleDataKeys.Lock()
+	defer bundleDataKeys.Unlock()
+
+	var root interface{}
+	err := storage.Txn(ctx, dataStore, storage.TransactionParams{}, func(txn storage.Transaction) error {
+		v, err := dataStore.Read(ctx, txn, storage.Path{})
+		if err != nil {
+			return err
+		}
+		// The store hands out its live documents, so they are copied while
+		// the transaction keeps writers out.
+		root = v
+		return util.RoundTrip(&root)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := root.(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	for _, key := range bundleDataKeys.keys {
+		delete(data, key)
+	}
+	for key, value := range bundleData {
+		data[key] = value
+	}
+	return data, nil
+}
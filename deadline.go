@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// grpcTimeoutUnits maps the unit suffixes of a gRPC grpc-timeout header to
+// their durations.
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// requestTimeout returns the evaluation budget the client asked for, taken
+// from X-Request-Deadline (an RFC 3339 timestamp) or a gRPC-style
+// grpc-timeout header (e.g. "250m"). Malformed headers are ignored.
+func requestTimeout(r *http.Request) (time.Duration, bool) {
+	if v := r.Header.Get("X-Request-Deadline"); v != "" {
+		if deadline, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return time.Until(deadline), true
+		}
+	}
+	if v := r.Header.Get("Grpc-Timeout"); len(v) > 1 {
+		unit, ok := grpcTimeoutUnits[v[len(v)-1]]
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if ok && err == nil && n >= 0 {
+			return time.Duration(n) * unit, true
+		}
+	}
+	return 0, false
+}
+
+// withRequestDeadline bounds ctx by the client's requested timeout, clamped
+// to `evaluate.maxDeadline`. Without a deadline header ctx is returned as-is.
+func withRequestDeadline(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	timeout, ok := requestTimeout(r)
+	if !ok {
+		return ctx, func() {}
+	}
+	if max := viper.GetDuration("evaluate.maxDeadline"); max > 0 && timeout > max {
+		timeout = max
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowPolicy takes far longer than any test deadline to decide.
+const slowPolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if count([x | some x in numbers.range(1, 100000000)]) > 0
+`
+
+func TestDeadlineHeaderBoundsEvaluation(t *testing.T) {
+	loadTestPolicy(t, stringLoader(slowPolicy))
+
+	for header, value := range map[string]string{
+		"Grpc-Timeout":       "50m",
+		"X-Request-Deadline": time.Now().Add(50 * time.Millisecond).Format(time.RFC3339Nano),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(`{"role": "admin"}`))
+		req.Header.Set(header, value)
+		start := time.Now()
+		rec := evaluateRequest(req)
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("%s: status = %d, want 504", header, rec.Code)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: evaluation ran for %v past a 50ms deadline", header, elapsed)
+		}
+	}
+}
+
+func TestRequestDeadlineIsClamped(t *testing.T) {
+	setConfig(t, "evaluate.maxDeadline", "100ms")
+	cases := map[string]time.Duration{
+		"10S": 100 * time.Millisecond,
+		"20m": 20 * time.Millisecond,
+		"bad": 0,
+		"-5m": 0,
+	}
+	for value, want := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/evaluate", nil)
+		req.Header.Set("Grpc-Timeout", value)
+		ctx, cancel := withRequestDeadline(context.Background(), req)
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if want == 0 {
+			if ok {
+				t.Errorf("grpc-timeout %q set a deadline", value)
+			}
+			continue
+		}
+		if got := time.Until(deadline); !ok || got > want || got < want-50*time.Millisecond {
+			t.Errorf("grpc-timeout %q left %v, want about %v", value, got, want)
+		}
+	}
+}
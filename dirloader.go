@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// moduleLoader is implemented by loaders that return several Rego modules,
+// keyed by module name, instead of a single policy.
+type moduleLoader interface {
+	LoadModules(ctx context.Context) (map[string]string, error)
+}
+
+// loadModules fetches the modules to compile from loader. Single-policy
+// loaders yield one module named after `policy.moduleName`, which is the
+// file name compile errors and annotations refer to.
+func loadModules(ctx context.Context, loader PolicyLoader) (map[string]string, error) {
+	if ml, ok := loader.(moduleLoader); ok {
+		return ml.LoadModules(ctx)
+	}
+	policyString, err := loader.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{viper.GetString("policy.moduleName"): policyString}, nil
+}
+
+// policySet is the result of a policy load: Rego modules keyed by module
+// name and the data documents shipped with them.
+type policySet struct {
+	modules map[string]string
+	data    map[string]interface{}
+	// Total size of the data documents as fetched, for policy.maxDataBytes.
+	dataSize int
+}
+
+// bundleLoader is implemented by loaders that return data documents along
+// with their modules.
+type bundleLoader interface {
+	LoadBundle(ctx context.Context) (policySet, error)
+}
+
+// loadPolicySet fetches modules and, from bundle loaders, data documents.
+func loadPolicySet(ctx context.Context, loader PolicyLoader) (policySet, error) {
+	if bl, ok := loader.(bundleLoader); ok {
+		return bl.LoadBundle(ctx)
+	}
+	modules, err := loadModules(ctx, loader)
+	return policySet{modules: modules}, err
+}
+
+// dirPolicyLoader reads every .rego file in a directory, such as a mounted
+// Kubernetes ConfigMap.
+type dirPolicyLoader struct {
+	path string
+}
+
+func (l *dirPolicyLoader) LoadModules(ctx context.Context) (map[string]string, error) {
+	entries, err := os.ReadDir(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy directory: %w", err)
+	}
+
+	modules := map[string]string{}
+	for _, entry := range entries {
+		// ConfigMap mounts expose files through symlinks, so resolve with
+		// os.ReadFile rather than trusting the entry type.
+		if !strings.HasSuffix(entry.Name(), ".rego") {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Join(l.path, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy file: %w", err)
+		}
+		modules[entry.Name()] = string(src)
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no .rego files found in %s", l.path)
+	}
+	return modules, nil
+}
+
+// Load returns the directory's policy when it holds exactly one module.
+func (l *dirPolicyLoader) Load(ctx context.Context) (string, error) {
+	modules, err := l.LoadModules(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(modules) != 1 {
+		return "", errors.New("policy directory holds several modules, use LoadModules")
+	}
+	for _, src := range modules {
+		return src, nil
+	}
+	return "", nil
+}
+
+// watchDebounce coalesces the burst of events a ConfigMap update produces.
+const watchDebounce = 500 * time.Millisecond
+
+// watchPolicyDir reloads the policy whenever the loader's directory changes,
+// until ctx is cancelled.
+func watchPolicyDir(ctx context.Context, loader *dirPolicyLoader) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if err := watcher.Add(loader.path); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", loader.path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.Events:
+				sugar.Debugw("Policy directory changed", "event", event.String())
+				debounce = time.After(watchDebounce)
+			case err := <-watcher.Errors:
+				sugar.Warnw("Policy directory watcher error", "error", err)
+			case <-debounce:
+				debounce = nil
+				if err := loadAndPreparePolicy(ctx, loader); err != nil {
+					sugar.Errorw("Failed to reload policy directory", "error", err)
+					continue
+				}
+				sugar.Infow("Reloaded policy directory", "path", loader.path)
+			}
+		}
+	}()
+	return nil
+}
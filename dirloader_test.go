@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// policyDir writes files into a temporary policy directory.
+func policyDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDirPolicyLoaderReadsRegoFiles(t *testing.T) {
+	dir := policyDir(t, map[string]string{"access.rego": accessPolicy, "README.md": "docs"})
+	modules, err := (&dirPolicyLoader{path: dir}).LoadModules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 1 || modules["access.rego"] != accessPolicy {
+		t.Errorf("modules = %v, want only access.rego", modules)
+	}
+
+	if _, err := (&dirPolicyLoader{path: t.TempDir()}).LoadModules(context.Background()); err == nil {
+		t.Error("empty directory loaded")
+	}
+}
+
+func TestWatchPolicyDirReloadsOnNewFile(t *testing.T) {
+	dir := policyDir(t, map[string]string{"access.rego": accessPolicy})
+	loader := &dirPolicyLoader{path: dir}
+	first := loadTestPolicy(t, loader)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := watchPolicyDir(ctx, loader); err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+
+	guests := "package api.access\n\nimport rego.v1\n\nallow if input.role == \"guest\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "guests.rego"), []byte(guests), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the directory reload", func() bool { return policies.Get() != first })
+
+	if modules := policies.Get().modules; len(modules) != 2 || !strings.Contains(modules["guests.rego"], "guest") {
+		t.Errorf("modules after reload = %v, want access.rego and guests.rego", modules)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "guest"}`); rec.Code != http.StatusOK {
+		t.Errorf("guest status after reload = %d, want 200", rec.Code)
+	}
+}
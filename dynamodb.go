@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/spf13/viper"
+)
+
+// dynamoDataLoader mirrors a DynamoDB table into dataStore. Each item becomes
+// an object under path, keyed by its keyAttribute, so a table of role maps
+// keyed by "id" is reachable as data.<path>[id].
+type dynamoDataLoader struct {
+	client       dynamodb.ScanAPIClient
+	table        string
+	keyAttribute string
+	path         storage.Path
+}
+
+// startDynamoDataLoader loads `data.dynamodb.table` once and then refreshes
+// it every `data.dynamodb.refreshInterval`. It does nothing when no table is
+// configured. The client is built from cfg, the AWS config main loaded for
+// S3. Failed refreshes keep the previously loaded items; refreshing stops
+// when ctx is cancelled.
+func startDynamoDataLoader(ctx context.Context, cfg aws.Config) error {
+	table := viper.GetString("data.dynamodb.table")
+	if table == "" {
+		return nil
+	}
+
+	path, ok := storage.ParsePathEscaped("/" + strings.ReplaceAll(viper.GetString("data.dynamodb.path"), ".", "/"))
+	if !ok || len(path) == 0 {
+		return fmt.Errorf("invalid data.dynamodb.path %q", viper.GetString("data.dynamodb.path"))
+	}
+	l := &dynamoDataLoader{
+		client:       dynamodb.NewFromConfig(cfg),
+		table:        table,
+		keyAttribute: viper.GetString("data.dynamodb.keyAttribute"),
+		path:         path,
+	}
+	if err := l.refresh(ctx); err != nil {
+		return err
+	}
+
+	interval := viper.GetDuration("data.dynamodb.refreshInterval")
+	if interval <= 0 {
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := l.refresh(ctx); err != nil && ctx.Err() == nil {
+				sugar.Errorw("DynamoDB data refresh failed, keeping previous data", "table", l.table, "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// refresh scans the whole table and replaces the data under l.path.
+func (l *dynamoDataLoader) refresh(ctx context.Context) error {
+	items := map[string]interface{}{}
+	paginator := dynamodb.NewScanPaginator(l.client, &dynamodb.ScanInput{TableName: aws.String(l.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan table %s: %w", l.table, err)
+		}
+		for _, item := range page.Items {
+			key, ok := item[l.keyAttribute].(*types.AttributeValueMemberS)
+			if !ok {
+				return fmt.Errorf("item in table %s has no string %q attribute", l.table, l.keyAttribute)
+			}
+			items[key.Value] = attributeValue(&types.AttributeValueMemberM{Value: item})
+		}
+	}
+
+	err := storage.Txn(ctx, dataStore, storage.WriteParams, func(txn storage.Transaction) error {
+		if err := storage.MakeDir(ctx, dataStore, txn, l.path[:len(l.path)-1]); err != nil {
+			return err
+		}
+		return dataStore.Write(ctx, txn, storage.AddOp, l.path, items)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store table %s: %w", l.table, err)
+	}
+	dataGeneration.Add(1)
+	sugar.Infow("Loaded DynamoDB data", "table", l.table, "items", len(items))
+	return refreshWasmQuery(ctx)
+}
+
+// attributeValue converts a DynamoDB attribute to the plain Go value OPA
+// expects. Numbers become float64, falling back to their string form when
+// they do not parse; binary attributes are not supported and become null.
+func attributeValue(av types.AttributeValue) interface{} {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		var f float64
+		if _, err := fmt.Sscan(v.Value, &f); err != nil {
+			return v.Value
+		}
+		return f
+	case *types.AttributeValueMemberBOOL:
+		return v.Value
+	case *types.AttributeValueMemberNULL:
+		return nil
+	case *types.AttributeValueMemberSS:
+		out := make([]interface{}, len(v.Value))
+		for i, s := range v.Value {
+			out[i] = s
+		}
+		return out
+	case *types.AttributeValueMemberL:
+		out := make([]interface{}, len(v.Value))
+		for i, e := range v.Value {
+			out[i] = attributeValue(e)
+		}
+		return out
+	case *types.AttributeValueMemberM:
+		out := make(map[string]interface{}, len(v.Value))
+		for k, e := range v.Value {
+			out[k] = attributeValue(e)
+		}
+		return out
+	}
+	return nil
+}
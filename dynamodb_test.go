@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// mockScanner serves pages of items, one per Scan call, or fails with err.
+type mockScanner struct {
+	pages [][]map[string]types.AttributeValue
+	err   error
+}
+
+func (m *mockScanner) Scan(ctx context.Context, in *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	page := 0
+	if in.ExclusiveStartKey != nil {
+		page = 1
+	}
+	out := &dynamodb.ScanOutput{Items: m.pages[page]}
+	if page+1 < len(m.pages) {
+		out.LastEvaluatedKey = map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "next"}}
+	}
+	return out, nil
+}
+
+func roleItem(id, role string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"id":   &types.AttributeValueMemberS{Value: id},
+		"role": &types.AttributeValueMemberS{Value: role},
+	}
+}
+
+const dynamoRolesPolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if data.reference.roles[input.user].role == "admin"
+`
+
+func TestDynamoDataDrivesDecisions(t *testing.T) {
+	loadTestPolicy(t, stringLoader(dynamoRolesPolicy))
+	scanner := &mockScanner{pages: [][]map[string]types.AttributeValue{
+		{roleItem("alice", "admin")},
+		{roleItem("bob", "reader")},
+	}}
+	l := &dynamoDataLoader{client: scanner, table: "roles", keyAttribute: "id", path: storage.Path{"reference", "roles"}}
+	t.Cleanup(func() {
+		storage.Txn(context.Background(), dataStore, storage.WriteParams, func(txn storage.Transaction) error {
+			return dataStore.Write(context.Background(), txn, storage.RemoveOp, storage.Path{"reference"}, nil)
+		})
+	})
+	if err := l.refresh(context.Background()); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+
+	for body, want := range map[string]int{
+		`{"user": "alice"}`: http.StatusOK,
+		`{"user": "bob"}`:   http.StatusForbidden,
+	} {
+		if rec := postEvaluate("/evaluate", body); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, want)
+		}
+	}
+
+	// A failed refresh keeps the items already loaded.
+	scanner.err = errors.New("throttled")
+	if err := l.refresh(context.Background()); err == nil {
+		t.Fatal("failed scan reported no error")
+	}
+	if rec := postEvaluate("/evaluate", `{"user": "alice"}`); rec.Code != http.StatusOK {
+		t.Errorf("status after a failed refresh = %d, want 200", rec.Code)
+	}
+}
+
+func TestAttributeValueConversion(t *testing.T) {
+	got := attributeValue(&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		"limit":   &types.AttributeValueMemberN{Value: "2.5"},
+		"enabled": &types.AttributeValueMemberBOOL{Value: true},
+		"tags":    &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "a"}}},
+	}}).(map[string]interface{})
+	if got["limit"] != 2.5 || got["enabled"] != true || got["tags"].([]interface{})[0] != "a" {
+		t.Errorf("converted item = %v", got)
+	}
+}
+
+func TestDynamoDataRefreshesUntilCancelled(t *testing.T) {
+	var scans atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scans.Add(1)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte(`{"Items": [{"id": {"S": "alice"}, "role": {"S": "admin"}}]}`))
+	}))
+	defer srv.Close()
+	setConfig(t, "data.dynamodb.table", "roles")
+	setConfig(t, "data.dynamodb.path", "reference.roles")
+	setConfig(t, "data.dynamodb.refreshInterval", "10ms")
+	t.Cleanup(func() {
+		storage.Txn(context.Background(), dataStore, storage.WriteParams, func(txn storage.Transaction) error {
+			return dataStore.Write(context.Background(), txn, storage.RemoveOp, storage.Path{"reference"}, nil)
+		})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := startDynamoDataLoader(ctx, aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to start the loader: %v", err)
+	}
+	waitFor(t, "an interval refresh", func() bool { return scans.Load() >= 3 })
+
+	// Cancelling the context stops the refresh ticker.
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	stopped := scans.Load()
+	time.Sleep(50 * time.Millisecond)
+	if got := scans.Load(); got != stopped {
+		t.Errorf("table scanned %d more times after cancel", got-stopped)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// computeRevision returns a content hash identifying a set of modules.
+func computeRevision(modules map[string]string) string {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(modules[name]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// decisionETag returns a weak ETag for evaluating the prepared input, with
+// any data overrides, against revision with generation of the data
+// documents. Hashing the prepared input rather than the request body covers
+// server-side enrichment such as the verified client certificate. variant
+// holds the request parameters besides the input that shape the response,
+// see responseVariant. encoding/json sorts map keys, so equal inputs hash
+// identically.
+func decisionETag(revision string, generation uint64, variant []string, input, overrides map[string]interface{}) (string, error) {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	overridesJSON, err := json.Marshal(overrides)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(revision))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatUint(generation, 10)))
+	h.Write([]byte{0})
+	for _, v := range variant {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	h.Write(inputJSON)
+	h.Write([]byte{0})
+	h.Write(overridesJSON)
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+}
+
+// responseVariant returns the request parameters other than the input and
+// the revision that change an /evaluate response: the selected query, the
+// reason and echo flags, and the Accept header choosing the encoding.
+func responseVariant(r *http.Request) []string {
+	q := r.URL.Query()
+	return []string{q.Get("query"), q.Get("requireReason"), q.Get("echoInput"), r.Header.Get("Accept")}
+}
+
+// etagMatches reports whether an If-None-Match header matches etag using
+// weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateHonorsIfNoneMatch(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.etag", true)
+
+	first := postEvaluate("/evaluate", `{"role": "admin", "action": "read"}`)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("first response: %d, ETag %q", first.Code, etag)
+	}
+	if got := first.Header().Get("Vary"); got != "Accept" {
+		t.Errorf("Vary = %q, want Accept", got)
+	}
+
+	// Key order does not change the input, so the ETag still matches.
+	req := httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(`{"action": "read", "role": "admin"}`))
+	req.Header.Set("If-None-Match", etag)
+	second := evaluateRequest(req)
+	if second.Code != http.StatusNotModified {
+		t.Errorf("conditional request status = %d, want 304", second.Code)
+	}
+	if second.Body.Len() != 0 {
+		t.Errorf("304 response has a body: %q", second.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(`{"role": "reader"}`))
+	req.Header.Set("If-None-Match", etag)
+	if rec := evaluateRequest(req); rec.Code == http.StatusNotModified {
+		t.Error("a different input matched the ETag")
+	}
+}
+
+func TestDecisionETagVariesWithResponseShapeAndData(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.etag", true)
+	etagOf := func(target, accept string) string {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"role": "admin"}`))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return evaluateRequest(req).Header().Get("ETag")
+	}
+
+	base := etagOf("/evaluate", "")
+	for _, variant := range []struct{ target, accept string }{
+		{"/evaluate?requireReason=true", ""},
+		{"/evaluate?echoInput=true", ""},
+		{"/evaluate", "application/x-protobuf"},
+	} {
+		if etagOf(variant.target, variant.accept) == base {
+			t.Errorf("%s with Accept %q shares the plain response's ETag", variant.target, variant.accept)
+		}
+	}
+
+	if err := replaceBundleData(context.Background(), map[string]interface{}{"roles": map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	if etagOf("/evaluate", "") == base {
+		t.Error("ETag unchanged after the data documents changed")
+	}
+}
+
+func TestDecisionETagCoversPreparedInput(t *testing.T) {
+	loadTestPolicy(t, stringLoader(clientCertPolicy))
+	setConfig(t, "evaluate.etag", true)
+	setConfig(t, "evaluate.dataOverrides", true)
+	request := func(commonName, body, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(body))
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: commonName}}}}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return evaluateRequest(req)
+	}
+
+	allowed := request("billing-service", `{}`, "")
+	if allowed.Code != http.StatusOK {
+		t.Fatalf("billing-service status = %d, want 200", allowed.Code)
+	}
+	etag := allowed.Header().Get("ETag")
+
+	// The same body from another certificate is decided differently.
+	if rec := request("other-service", `{}`, etag); rec.Code != http.StatusForbidden {
+		t.Errorf("other certificate with the allowed ETag: status = %d, want 403", rec.Code)
+	}
+	if rec := request("billing-service", `{"input": {}, "data": {"x": 1}}`, etag); rec.Code == http.StatusNotModified {
+		t.Error("a request with data overrides matched the ETag of one without")
+	}
+	if rec := request("billing-service", `{}`, etag); rec.Code != http.StatusNotModified {
+		t.Errorf("repeated request status = %d, want 304", rec.Code)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	etag := `W/"abc"`
+	for header, want := range map[string]bool{
+		`W/"abc"`:        true,
+		`"abc"`:          true,
+		`"xyz", W/"abc"`: true,
+		`*`:              true,
+		`W/"xyz"`:        false,
+		`"abcd"`:         false,
+	} {
+		if got := etagMatches(header, etag); got != want {
+			t.Errorf("etagMatches(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// Decision is the outcome of evaluating a query for one input.
+type Decision struct {
+	// Defined is false when the query produced no result, e.g. because no
+	// allow rule matched and the policy has no default.
+	Defined bool
+	// Result is the raw value of the query; nil when undefined.
+	Result interface{}
+}
+
+// Allow returns the decision as a boolean. ok is false when the query is
+// undefined or its result is not a boolean.
+func (d Decision) Allow() (allow, ok bool) {
+	allow, ok = d.Result.(bool)
+	return allow, ok
+}
+
+// Evaluate evaluates query for input. It is the evaluation core shared by
+// the HTTP handlers, kept free of any request handling so it can be driven
+// directly.
+func Evaluate(ctx context.Context, query *rego.PreparedEvalQuery, input map[string]interface{}) (Decision, error) {
+	evaluationsTotal.Inc()
+	start := time.Now()
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	evaluationDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := decisionFromResults(results)
+	outcome := "other"
+	if allow, ok := decision.Allow(); ok {
+		outcome = "deny"
+		if allow {
+			outcome = "allow"
+		}
+	}
+	decisionsTotal.WithLabelValues(outcome).Inc()
+	return decision, nil
+}
+
+// decisionFromResults takes the first expression value of results as the
+// decision.
+func decisionFromResults(results rego.ResultSet) Decision {
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return Decision{}
+	}
+	return Decision{Defined: true, Result: results[0].Expressions[0].Value}
+}
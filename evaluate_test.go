@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// compileQuery prepares query against a single policy module.
+func compileQuery(t *testing.T, query, module string) *rego.PreparedEvalQuery {
+	t.Helper()
+	pq, err := prepareQuery(context.Background(), query, map[string]string{"policy.rego": module})
+	if err != nil {
+		t.Fatalf("failed to prepare %s: %v", query, err)
+	}
+	return &pq
+}
+
+func TestEvaluateDecisions(t *testing.T) {
+	query := compileQuery(t, "data.api.access.allow", accessPolicy)
+	for input, want := range map[string]bool{"admin": true, "guest": false} {
+		denied := readMetric(t, decisionsTotal.WithLabelValues("deny")).GetCounter().GetValue()
+		decision, err := Evaluate(context.Background(), query, map[string]interface{}{"role": input})
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if allow, ok := decision.Allow(); !decision.Defined || !ok || allow != want {
+			t.Errorf("%s: decision = %+v, want allow=%v", input, decision, want)
+		}
+		wantDenied := 1.0
+		if want {
+			wantDenied = 0
+		}
+		if got := readMetric(t, decisionsTotal.WithLabelValues("deny")).GetCounter().GetValue() - denied; got != wantDenied {
+			t.Errorf("%s: deny counter advanced by %v, want %v", input, got, wantDenied)
+		}
+	}
+}
+
+func TestEvaluateUndefinedAndNonBoolean(t *testing.T) {
+	query := compileQuery(t, "data.api.access.allow", "package api.access\n\nimport rego.v1\n\nallow if input.role == \"admin\"\n")
+	decision, err := Evaluate(context.Background(), query, map[string]interface{}{"role": "guest"})
+	if err != nil || decision.Defined || decision.Result != nil {
+		t.Errorf("undefined decision = %+v, %v", decision, err)
+	}
+	if _, ok := decision.Allow(); ok {
+		t.Error("undefined decision reported a boolean")
+	}
+
+	query = compileQuery(t, "data.api.access.label", "package api.access\n\nlabel := \"restricted\"\n")
+	decision, err = Evaluate(context.Background(), query, nil)
+	if err != nil || !decision.Defined || decision.Result != "restricted" {
+		t.Errorf("string decision = %+v, %v", decision, err)
+	}
+	if _, ok := decision.Allow(); ok {
+		t.Error("string decision reported a boolean")
+	}
+}
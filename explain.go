@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/lineage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// failedCondition is a policy expression that evaluated to false while the
+// allow query was being decided.
+type failedCondition struct {
+	File       string `json:"file"`
+	Row        int    `json:"row"`
+	Expression string `json:"expression"`
+}
+
+// explainHandler evaluates an input with tracing enabled and, when it is
+// denied, reports why: the policy conditions that failed, any trace()
+// notes the policy emitted, and the reason query's result if configured.
+// An undefined allow rule counts as a denial here.
+func explainHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input == nil {
+		http.Error(w, "Request body must be a JSON object", http.StatusBadRequest)
+		return
+	}
+	// Explanations must describe the input /evaluate would decide, so it
+	// goes through the same aliases, transforms and checks.
+	input, err := prepareInput(r, input)
+	if err != nil {
+		writeInputError(w, r, logger, err)
+		return
+	}
+
+	policy := policies.Get()
+	if policy == nil {
+		http.Error(w, "Policy not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !evalSlots.acquire(r.Context()) {
+		w.Header().Set("Retry-After", strconv.Itoa(viper.GetInt("evaluate.retryAfter")))
+		http.Error(w, "Too many concurrent evaluations", http.StatusTooManyRequests)
+		return
+	}
+	defer evalSlots.release()
+
+	tracer := topdown.NewBufferTracer()
+	// Rule indexing would skip rules whose first condition cannot match,
+	// hiding exactly the failures this endpoint reports. Wasm queries cannot
+	// be traced, so the interpreted query is used whatever the target.
+	results, err := policy.interpretedQuery.Eval(r.Context(), rego.EvalInput(input), rego.EvalQueryTracer(tracer), rego.EvalRuleIndexing(false))
+	if err != nil {
+		logInternalError(logger, "Policy evaluation failed", err)
+		http.Error(w, "Policy evaluation failed", http.StatusInternalServerError)
+		return
+	}
+
+	allow := false
+	if len(results) > 0 {
+		allow, _ = results[0].Expressions[0].Value.(bool)
+	}
+	if allow {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"allow": true})
+		return
+	}
+
+	notes := []string{}
+	for _, event := range lineage.Notes(*tracer) {
+		if event.Op == topdown.NoteOp {
+			notes = append(notes, event.Message)
+		}
+	}
+
+	resp := map[string]interface{}{
+		"allow":    false,
+		"failures": failedConditions(*tracer),
+		"notes":    notes,
+	}
+	if policy.reasonQuery != nil {
+		if reason, err := decisionReason(r.Context(), policy, input); err == nil {
+			resp["reason"] = reason
+		}
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// failedConditions returns the distinct policy expressions that failed in
+// trace, in evaluation order. Failures of the query itself, which has no
+// file, are left out.
+func failedConditions(trace []*topdown.Event) []failedCondition {
+	seen := map[string]bool{}
+	failures := []failedCondition{}
+	for _, event := range lineage.Fails(trace) {
+		expr, ok := event.Node.(*ast.Expr)
+		if !ok || event.Location == nil || event.Location.File == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d:%d", event.Location.File, event.Location.Row, event.Location.Col)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		failures = append(failures, failedCondition{
+			File:       event.Location.File,
+			Row:        event.Location.Row,
+			Expression: expr.String(),
+		})
+	}
+	return failures
+}
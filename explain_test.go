@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// explainPolicy denies writes by non-owners, noting which check failed.
+const explainPolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if {
+	input.role == "editor"
+	trace("checking ownership")
+	input.owner == input.user
+}
+`
+
+type explanation struct {
+	Allow    bool              `json:"allow"`
+	Failures []failedCondition `json:"failures"`
+	Notes    []string          `json:"notes"`
+}
+
+func postExplain(t *testing.T, body string) explanation {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	explainHandler(rec, httptest.NewRequest(http.MethodPost, "/explain", strings.NewReader(body)), sugar)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("explain status = %d: %s", rec.Code, rec.Body)
+	}
+	var e explanation
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+		t.Fatalf("invalid explanation: %v", err)
+	}
+	return e
+}
+
+func TestExplainIdentifiesFailingCondition(t *testing.T) {
+	loadTestPolicy(t, stringLoader(explainPolicy))
+
+	e := postExplain(t, `{"role": "editor", "user": "alice", "owner": "bob"}`)
+	if e.Allow || len(e.Failures) != 1 {
+		t.Fatalf("explanation = %+v, want one failed condition", e)
+	}
+	if got := e.Failures[0].Expression; got != "input.owner = input.user" {
+		t.Errorf("failed condition = %q, want the ownership check", got)
+	}
+	if len(e.Notes) != 1 || e.Notes[0] != "checking ownership" {
+		t.Errorf("notes = %q, want the trace note", e.Notes)
+	}
+
+	// The role check fails first for a viewer, so ownership is not reached.
+	e = postExplain(t, `{"role": "viewer", "user": "alice", "owner": "alice"}`)
+	if len(e.Failures) != 1 || e.Failures[0].Expression != `input.role = "editor"` || len(e.Notes) != 0 {
+		t.Errorf("viewer explanation = %+v, want only the role check", e)
+	}
+
+	if e := postExplain(t, `{"role": "editor", "user": "alice", "owner": "alice"}`); !e.Allow || len(e.Failures) != 0 {
+		t.Errorf("allowed explanation = %+v", e)
+	}
+}
+
+func TestExplainPreparesInputLikeEvaluate(t *testing.T) {
+	loadTestPolicy(t, stringLoader(explainPolicy))
+	useFieldAliases(t, []map[string]interface{}{{"from": "userRole", "to": "role"}})
+
+	if e := postExplain(t, `{"userRole": "editor", "user": "alice", "owner": "alice"}`); !e.Allow {
+		t.Errorf("aliased input explained as %+v, want allowed", e)
+	}
+}
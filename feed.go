@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// decisionFeed fans decision events out to live subscribers of
+// /decisions/stream. Slow subscribers miss events rather than slowing down
+// evaluation.
+type decisionFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan decisionEvent]struct{}
+	// Closed by shutdown to disconnect every subscriber.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var feed = newDecisionFeed()
+
+func newDecisionFeed() *decisionFeed {
+	return &decisionFeed{subscribers: map[chan decisionEvent]struct{}{}, done: make(chan struct{})}
+}
+
+// shutdown disconnects all subscribers. It is registered with
+// http.Server.RegisterOnShutdown, since Shutdown waits for active requests
+// and streams would otherwise hold it until its timeout.
+func (f *decisionFeed) shutdown() {
+	f.closeOnce.Do(func() { close(f.done) })
+}
+
+func (f *decisionFeed) subscribe() chan decisionEvent {
+	ch := make(chan decisionEvent, 64)
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *decisionFeed) unsubscribe(ch chan decisionEvent) {
+	f.mu.Lock()
+	delete(f.subscribers, ch)
+	f.mu.Unlock()
+}
+
+// broadcast sends event to every subscriber without blocking.
+func (f *decisionFeed) broadcast(event decisionEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// decisionFeedHandler streams decisions as server-sent events. Subscribers
+// are disconnected after `decisions.feed.maxLifetime`, or once no event has
+// been sent for `decisions.feed.idleTimeout`, so abandoned connections do
+// not pile up; zero disables either limit. Shutdown disconnects everyone.
+func decisionFeedHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "GET" {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := feed.subscribe()
+	defer feed.unsubscribe(ch)
+
+	var lifetime, idle <-chan time.Time
+	if d := viper.GetDuration("decisions.feed.maxLifetime"); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+	idleTimeout := viper.GetDuration("decisions.feed.idleTimeout")
+	var idleTimer *time.Timer
+	if idleTimeout > 0 {
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-feed.done:
+			logger.Debugw("Closing decision feed subscriber", "reason", "shutdown")
+			return
+		case <-lifetime:
+			logger.Debugw("Closing decision feed subscriber", "reason", "max lifetime")
+			return
+		case <-idle:
+			logger.Debugw("Closing decision feed subscriber", "reason", "idle")
+			return
+		case event := <-ch:
+			body, err := json.Marshal(event)
+			if err != nil {
+				logInternalError(logger, "Failed to encode decision event", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", body); err != nil {
+				return
+			}
+			flusher.Flush()
+			if idleTimer != nil {
+				idleTimer.Reset(idleTimeout)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscribeFeed opens /decisions/stream on a fresh feed, returning the
+// feed and the open response.
+func subscribeFeed(t *testing.T) (*decisionFeed, *http.Response) {
+	t.Helper()
+	previous := feed
+	feed = newDecisionFeed()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decisionFeedHandler(w, r, sugar)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		feed = previous
+	})
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return feed, resp
+}
+
+// closedWithin reports whether the stream ends within d.
+func closedWithin(resp *http.Response, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestIdleFeedSubscriberIsDisconnected(t *testing.T) {
+	setConfig(t, "decisions.feed.idleTimeout", "100ms")
+	setConfig(t, "decisions.feed.maxLifetime", "0")
+	f, resp := subscribeFeed(t)
+
+	// An event resets the idle timer.
+	time.Sleep(60 * time.Millisecond)
+	f.broadcast(decisionEvent{DecisionID: "d-1"})
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.Contains(line, `"d-1"`) {
+		t.Fatalf("first event = %q, %v", line, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !closedWithin(resp, time.Second) {
+		t.Fatal("idle subscriber still connected")
+	}
+}
+
+func TestFeedSubscriberLifetime(t *testing.T) {
+	setConfig(t, "decisions.feed.idleTimeout", "0")
+	setConfig(t, "decisions.feed.maxLifetime", "50ms")
+	_, resp := subscribeFeed(t)
+	if !closedWithin(resp, time.Second) {
+		t.Error("subscriber outlived decisions.feed.maxLifetime")
+	}
+}
+
+func TestFeedShutdownDisconnectsSubscribers(t *testing.T) {
+	setConfig(t, "decisions.feed.idleTimeout", "0")
+	setConfig(t, "decisions.feed.maxLifetime", "0")
+	f, resp := subscribeFeed(t)
+	f.shutdown()
+	if !closedWithin(resp, time.Second) {
+		t.Error("subscriber still connected after shutdown")
+	}
+}
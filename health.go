@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// readiness tracks whether a policy is loaded, whether the most recent fetch
+// succeeded, and when the service may report ready again after the last
+// (re)load.
+var readiness struct {
+	sync.Mutex
+	loaded      bool
+	fetchFailed bool
+	draining    bool
+	readyAt     time.Time
+}
+
+// markPolicyLoaded records a successful policy load. Readiness is held back
+// for `server.readyGracePeriod` so the new query settles before probes pass.
+func markPolicyLoaded() {
+	grace := viper.GetDuration("server.readyGracePeriod")
+
+	readiness.Lock()
+	defer readiness.Unlock()
+	readiness.loaded = true
+	readiness.fetchFailed = false
+	readiness.readyAt = time.Now().Add(grace)
+}
+
+// markFetchFailed records a failed policy fetch. The service keeps serving
+// any previously loaded policy but reports not ready until a fetch succeeds.
+func markFetchFailed() {
+	readiness.Lock()
+	defer readiness.Unlock()
+	readiness.fetchFailed = true
+}
+
+// markDraining permanently marks the service not ready ahead of shutdown.
+// It returns false if the service was already draining.
+func markDraining() bool {
+	readiness.Lock()
+	defer readiness.Unlock()
+	if readiness.draining {
+		return false
+	}
+	readiness.draining = true
+	return true
+}
+
+// isReady reports whether a policy is loaded, the last fetch succeeded, its
+// grace period is over and the service is not draining.
+func isReady() bool {
+	readiness.Lock()
+	defer readiness.Unlock()
+	return readiness.loaded && !readiness.fetchFailed && !readiness.draining && !time.Now().Before(readiness.readyAt)
+}
+
+// healthzHandler is the liveness probe: it answers 200 whenever the process
+// is serving, regardless of policy state.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !isReady() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
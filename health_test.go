@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// resetReadiness clears the readiness state, restoring it when the test ends.
+func resetReadiness(t *testing.T) {
+	t.Helper()
+	readiness.Lock()
+	saved := struct {
+		loaded, fetchFailed, draining bool
+		readyAt                       time.Time
+	}{readiness.loaded, readiness.fetchFailed, readiness.draining, readiness.readyAt}
+	readiness.loaded, readiness.fetchFailed, readiness.draining, readiness.readyAt = false, false, false, time.Time{}
+	readiness.Unlock()
+
+	t.Cleanup(func() {
+		readiness.Lock()
+		defer readiness.Unlock()
+		readiness.loaded, readiness.fetchFailed, readiness.draining, readiness.readyAt = saved.loaded, saved.fetchFailed, saved.draining, saved.readyAt
+	})
+}
+
+func readyzStatus() int {
+	rec := httptest.NewRecorder()
+	readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code
+}
+
+func TestReadinessWaitsForGracePeriod(t *testing.T) {
+	resetReadiness(t)
+	setConfig(t, "server.readyGracePeriod", "100ms")
+	if got := readyzStatus(); got != http.StatusServiceUnavailable {
+		t.Fatalf("status before any load = %d, want 503", got)
+	}
+
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	if got := readyzStatus(); got != http.StatusServiceUnavailable {
+		t.Errorf("status within the grace period = %d, want 503", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if got := readyzStatus(); got != http.StatusOK {
+		t.Errorf("status after the grace period = %d, want 200", got)
+	}
+}
+
+func TestReadinessWithoutGracePeriod(t *testing.T) {
+	resetReadiness(t)
+	setConfig(t, "server.readyGracePeriod", "0s")
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	if got := readyzStatus(); got != http.StatusOK {
+		t.Errorf("status = %d, want 200", got)
+	}
+
+	markFetchFailed()
+	if got := readyzStatus(); got != http.StatusServiceUnavailable {
+		t.Errorf("status after a failed fetch = %d, want 503", got)
+	}
+}
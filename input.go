@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// inputError is an input rejected by prepareInput. Schema violations are
+// unprocessable rather than malformed, so they carry their own status.
+type inputError struct {
+	status     int
+	message    string
+	violations []string
+}
+
+func (e *inputError) Error() string {
+	if len(e.violations) > 0 {
+		return strings.Join(e.violations, "; ")
+	}
+	return e.message
+}
+
+// prepareInput runs an input document through the steps shared by
+// /evaluate, /evaluate/batch and /explain, in order: size limits, field
+// aliases, the verified client certificate, the transform pipeline, type
+// coercion and schema validation. Rejections are returned as *inputError;
+// any other error is internal.
+func prepareInput(r *http.Request, input map[string]interface{}) (map[string]interface{}, error) {
+	if err := checkInputLimits(input, viper.GetInt("evaluate.maxDepth"), viper.GetInt("evaluate.maxKeys")); err != nil {
+		return nil, &inputError{status: http.StatusBadRequest, message: err.Error()}
+	}
+
+	input = applyFieldAliases(input)
+	// The verified client certificate replaces anything the client sent
+	// under the same key.
+	if cert := clientCertInput(r); cert != nil {
+		input = copyMap(input)
+		input["clientCert"] = cert
+	}
+
+	input, err := transformInput(input)
+	if err != nil {
+		return nil, &inputError{status: http.StatusBadRequest, message: err.Error()}
+	}
+
+	input, err = coerceInput(input)
+	if err != nil {
+		return nil, &inputError{status: http.StatusBadRequest, message: err.Error()}
+	}
+
+	violations, err := validateInput(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		return nil, &inputError{status: http.StatusUnprocessableEntity, violations: violations}
+	}
+	return input, nil
+}
+
+// writeInputError logs and answers an error returned by prepareInput.
+func writeInputError(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, err error) {
+	var rejected *inputError
+	if !errors.As(err, &rejected) {
+		logInternalError(logger, "Failed to validate input", err)
+		http.Error(w, "Failed to validate input", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Warnw("Rejected input", "error", rejected)
+	if len(rejected.violations) > 0 {
+		writeJSON(w, r, rejected.status, map[string]interface{}{"errors": rejected.violations})
+		return
+	}
+	http.Error(w, rejected.message, rejected.status)
+}
+
+// recordDecision writes the decision log line and hands the event to the
+// NATS stream and the live feed. Every evaluation goes through it, whatever
+// format its response is written in.
+func recordDecision(logger *zap.SugaredLogger, decisionID, revision string, allow bool, result interface{}, input map[string]interface{}) {
+	event := decisionEvent{
+		DecisionID: decisionID,
+		Timestamp:  time.Now().UTC(),
+		Revision:   revision,
+		Allow:      allow,
+		Input:      redactInput(input, viper.GetStringSlice("log.redactFields")),
+	}
+	decisionLog(logger, event, result)
+	decisions.publish(event)
+	feed.broadcast(event)
+}
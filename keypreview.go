@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// keyPreviewHandler reports the object key a generate request with the same
+// body would write to, and whether an object already exists there.
+func keyPreviewHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var policyData PolicyData
+	if err := json.NewDecoder(r.Body).Decode(&policyData); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if errs := policyData.Validate(); errs != nil {
+		writeJSON(w, r, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+		return
+	}
+
+	key := policyObjectKey(policyData)
+	exists, err := objectExists(r.Context(), sharedS3Client, key)
+	if err != nil {
+		logInternalError(logger, "Failed to check policy object", err, "objectKey", key)
+		http.Error(w, "Failed to check policy object", http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"key": key, "exists": exists})
+}
+
+// objectExists reports whether key exists in the configured bucket.
+func objectExists(ctx context.Context, s3Client *s3.Client, key string) (bool, error) {
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(viper.GetString("s3.bucketName")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func previewKey(t *testing.T, body string) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	keyPreviewHandler(rec, httptest.NewRequest(http.MethodPost, "/generate-policy/key-preview", strings.NewReader(body)), sugar)
+	var out map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("invalid preview response: %v", err)
+	}
+	return rec.Code, out
+}
+
+func TestKeyPreviewReportsKeyAndExistence(t *testing.T) {
+	fake := useFakeS3(t)
+
+	code, out := previewKey(t, samplePolicyData)
+	if code != http.StatusOK || out["key"] != billingPolicyKey || out["exists"] != false {
+		t.Fatalf("preview before upload = %d %v, want %s not existing", code, out, billingPolicyKey)
+	}
+
+	fake.put(billingPolicyKey, accessPolicy)
+	if _, out := previewKey(t, samplePolicyData); out["exists"] != true {
+		t.Errorf("preview after upload = %v, want existing", out)
+	}
+	// Previewing never writes.
+	if len(fake.puts) != 0 {
+		t.Errorf("preview uploaded %d objects", len(fake.puts))
+	}
+}
+
+func TestKeyPreviewValidatesPolicyData(t *testing.T) {
+	useFakeS3(t)
+	if code, out := previewKey(t, `{"ApplicationName": "billing"}`); code != http.StatusBadRequest || out["errors"] == nil {
+		t.Errorf("incomplete body = %d %v, want 400 with errors", code, out)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// evalLimiter bounds concurrent policy evaluations. Requests beyond the
+// concurrency limit wait for a slot, and once more than maxQueue are already
+// waiting new requests are shed instead of queued.
+type evalLimiter struct {
+	slots    chan struct{}
+	maxQueue int64
+	waiting  atomic.Int64
+}
+
+// newEvalLimiter returns a limiter allowing maxConcurrent evaluations, or nil
+// (no limit) when maxConcurrent is zero or less.
+func newEvalLimiter(maxConcurrent, maxQueue int) *evalLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &evalLimiter{
+		slots:    make(chan struct{}, maxConcurrent),
+		maxQueue: int64(maxQueue),
+	}
+}
+
+// acquire takes an evaluation slot, waiting if necessary. It returns false
+// when the queue is full or ctx ends first; the caller must not release.
+func (l *evalLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	// Join the queue before checking its length, so concurrent callers
+	// cannot all pass the check and overfill it.
+	n := l.waiting.Add(1)
+	if n > l.maxQueue {
+		l.waiting.Add(-1)
+		return false
+	}
+	evalQueueDepth.Set(float64(n))
+	defer func() { evalQueueDepth.Set(float64(l.waiting.Add(-1))) }()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by a successful acquire.
+func (l *evalLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useEvalLimiter installs a limiter for the test.
+func useEvalLimiter(t *testing.T, maxConcurrent, maxQueue int) *evalLimiter {
+	t.Helper()
+	previous := evalSlots
+	evalSlots = newEvalLimiter(maxConcurrent, maxQueue)
+	t.Cleanup(func() { evalSlots = previous })
+	return evalSlots
+}
+
+func TestEvaluateShedsLoadWhenQueueIsFull(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.retryAfter", 3)
+	limiter := useEvalLimiter(t, 1, 1)
+
+	// Hold the only slot, then queue one request behind it.
+	if !limiter.acquire(context.Background()) {
+		t.Fatal("failed to take the only slot")
+	}
+	queued := make(chan int)
+	go func() { queued <- postEvaluate("/evaluate", `{"role": "admin"}`).Code }()
+
+	deadline := time.Now().Add(time.Second)
+	for readMetric(t, evalQueueDepth).GetGauge().GetValue() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("request never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := postEvaluate("/evaluate", `{"role": "admin"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status with a full queue = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want 3", got)
+	}
+
+	limiter.release()
+	if got := <-queued; got != http.StatusOK {
+		t.Errorf("queued request status = %d, want 200", got)
+	}
+	if got := readMetric(t, evalQueueDepth).GetGauge().GetValue(); got != 0 {
+		t.Errorf("queue depth after draining = %v, want 0", got)
+	}
+}
+
+func TestConcurrentAcquireNeverOverfillsQueue(t *testing.T) {
+	const maxQueue, callers = 3, 64
+	limiter := newEvalLimiter(1, maxQueue)
+	if !limiter.acquire(context.Background()) {
+		t.Fatal("failed to take the only slot")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var shed atomic.Int64
+	results := make(chan bool, callers)
+	start := make(chan struct{})
+	for i := 0; i < callers; i++ {
+		go func() {
+			<-start
+			ok := limiter.acquire(ctx)
+			if !ok && ctx.Err() == nil {
+				shed.Add(1)
+			}
+			results <- ok
+		}()
+	}
+	close(start)
+
+	// Callers beyond the queue bound are shed; the rest stay queued.
+	waitFor(t, "callers beyond the queue bound to be shed", func() bool { return shed.Load() == callers-maxQueue })
+	if got := limiter.waiting.Load(); got != maxQueue {
+		t.Errorf("%d callers queued, want %d", got, maxQueue)
+	}
+
+	cancel()
+	for i := 0; i < callers; i++ {
+		if <-results {
+			t.Error("a caller took a slot that was never released")
+		}
+	}
+}
+
+func TestNilLimiterIsUnlimited(t *testing.T) {
+	limiter := newEvalLimiter(0, 0)
+	for i := 0; i < 3; i++ {
+		if !limiter.acquire(context.Background()) {
+			t.Fatal("unlimited limiter refused a slot")
+		}
+	}
+	limiter.release()
+}
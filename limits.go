@@ -0,0 +1,37 @@
+package main
+
+import "fmt"
+
+// checkInputLimits rejects decoded JSON that nests deeper than maxDepth or
+// contains more than maxKeys object keys in total. A limit of zero or less
+// disables that check.
+func checkInputLimits(v interface{}, maxDepth, maxKeys int) error {
+	keys := 0
+	return walkInput(v, 1, maxDepth, maxKeys, &keys)
+}
+
+func walkInput(v interface{}, depth, maxDepth, maxKeys int, keys *int) error {
+	if maxDepth > 0 && depth > maxDepth {
+		return fmt.Errorf("input exceeds maximum nesting depth of %d", maxDepth)
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		*keys += len(val)
+		if maxKeys > 0 && *keys > maxKeys {
+			return fmt.Errorf("input exceeds maximum of %d keys", maxKeys)
+		}
+		for _, child := range val {
+			if err := walkInput(child, depth+1, maxDepth, maxKeys, keys); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range val {
+			if err := walkInput(child, depth+1, maxDepth, maxKeys, keys); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// nestedJSON returns an object nesting depth levels deep.
+func nestedJSON(depth int) string {
+	return strings.Repeat(`{"a":`, depth) + "1" + strings.Repeat("}", depth)
+}
+
+func TestCheckInputLimits(t *testing.T) {
+	nested := map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{map[string]interface{}{"c": 1}}}}
+	if err := checkInputLimits(nested, 5, 0); err != nil {
+		t.Errorf("input within depth rejected: %v", err)
+	}
+	if err := checkInputLimits(nested, 4, 0); err == nil {
+		t.Error("input deeper than the limit accepted")
+	}
+	if err := checkInputLimits(nested, 0, 3); err != nil {
+		t.Errorf("input within key limit rejected: %v", err)
+	}
+	if err := checkInputLimits(nested, 0, 2); err == nil {
+		t.Error("input with too many keys accepted")
+	}
+}
+
+func TestEvaluateRejectsPathologicallyNestedInput(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.maxDepth", 32)
+
+	rec := postEvaluate("/evaluate", nestedJSON(5000))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "maximum nesting depth of 32") {
+		t.Errorf("body = %q, want the depth limit", rec.Body)
+	}
+}
+
+func TestEvaluateRejectsTooManyKeys(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.maxKeys", 10)
+
+	var fields []string
+	for i := 0; i < 11; i++ {
+		fields = append(fields, fmt.Sprintf(`"k%d": %d`, i, i))
+	}
+	rec := postEvaluate("/evaluate", "{"+strings.Join(fields, ",")+"}")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// PolicyLoader fetches the raw Rego source the service evaluates against.
+type PolicyLoader interface {
+	Load(ctx context.Context) (string, error)
+}
+
+// newPolicyLoader returns the loader selected by `policy.loader`.
+// Supported values are "s3" (default), "file", "http" and "dir".
+func newPolicyLoader() (PolicyLoader, error) {
+	switch kind := viper.GetString("policy.loader"); kind {
+	case "", "s3":
+		if prefix := viper.GetString("s3.policyPrefix"); prefix != "" {
+			return s3PrefixLoader{prefix: prefix}, nil
+		}
+		return s3PolicyLoader{}, nil
+	case "file":
+		path := viper.GetString("policy.filePath")
+		if path == "" {
+			return nil, fmt.Errorf("policy.filePath is required for the file loader")
+		}
+		return filePolicyLoader{path: path}, nil
+	case "http":
+		url := viper.GetString("policy.url")
+		if url == "" {
+			return nil, fmt.Errorf("policy.url is required for the http loader")
+		}
+		return httpPolicyLoader{url: url, client: http.DefaultClient}, nil
+	case "dir":
+		path := viper.GetString("policy.dirPath")
+		if path == "" {
+			return nil, fmt.Errorf("policy.dirPath is required for the dir loader")
+		}
+		return &dirPolicyLoader{path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown policy loader %q", kind)
+	}
+}
+
+// s3PolicyLoader reads the policy object configured under `s3`.
+type s3PolicyLoader struct{}
+
+func (s3PolicyLoader) Load(ctx context.Context) (string, error) {
+	return fetchPolicyFromS3(ctx)
+}
+
+// filePolicyLoader reads the policy from the local filesystem.
+type filePolicyLoader struct {
+	path string
+}
+
+func (l filePolicyLoader) Load(ctx context.Context) (string, error) {
+	policyBytes, err := os.ReadFile(l.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read policy file: %w", err)
+	}
+	return string(policyBytes), nil
+}
+
+// httpPolicyLoader downloads the policy with a GET request.
+type httpPolicyLoader struct {
+	url    string
+	client *http.Client
+}
+
+func (l httpPolicyLoader) Load(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build policy request: %w", err)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch policy: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch policy: unexpected status %s", resp.Status)
+	}
+
+	policyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read policy body: %w", err)
+	}
+	return string(policyBytes), nil
+}
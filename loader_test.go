@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewPolicyLoader(t *testing.T) {
+	setConfig(t, "policy.filePath", "/etc/policy.rego")
+	setConfig(t, "policy.url", "https://policies.example.com/policy.rego")
+	setConfig(t, "policy.dirPath", "/etc/policies")
+	setConfig(t, "s3.policyPrefix", "")
+
+	cases := []struct {
+		kind string
+		want PolicyLoader
+	}{
+		{"", s3PolicyLoader{}},
+		{"s3", s3PolicyLoader{}},
+		{"file", filePolicyLoader{path: "/etc/policy.rego"}},
+		{"http", httpPolicyLoader{url: "https://policies.example.com/policy.rego", client: http.DefaultClient}},
+	}
+	for _, c := range cases {
+		setConfig(t, "policy.loader", c.kind)
+		got, err := newPolicyLoader()
+		if err != nil {
+			t.Errorf("loader %q: %v", c.kind, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("loader %q = %#v, want %#v", c.kind, got, c.want)
+		}
+	}
+
+	setConfig(t, "policy.loader", "ftp")
+	if _, err := newPolicyLoader(); err == nil {
+		t.Error("unknown loader accepted")
+	}
+	setConfig(t, "policy.loader", "file")
+	setConfig(t, "policy.filePath", "")
+	if _, err := newPolicyLoader(); err == nil {
+		t.Error("file loader without a path accepted")
+	}
+}
+
+func TestFilePolicyLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	if err := os.WriteFile(path, []byte(accessPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := filePolicyLoader{path: path}.Load(context.Background())
+	if err != nil || got != accessPolicy {
+		t.Errorf("Load() = %q, %v", got, err)
+	}
+	if _, err := (filePolicyLoader{path: path + ".missing"}).Load(context.Background()); err == nil {
+		t.Error("missing file loaded")
+	}
+}
+
+func TestHTTPPolicyLoader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/policy.rego" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(accessPolicy))
+	}))
+	defer srv.Close()
+
+	got, err := httpPolicyLoader{url: srv.URL + "/policy.rego", client: srv.Client()}.Load(context.Background())
+	if err != nil || got != accessPolicy {
+		t.Errorf("Load() = %q, %v", got, err)
+	}
+	if _, err := (httpPolicyLoader{url: srv.URL + "/missing", client: srv.Client()}).Load(context.Background()); err == nil {
+		t.Error("404 response loaded")
+	}
+}
+
+func TestLoadAndPreparePolicyWithMockLoader(t *testing.T) {
+	policy := loadTestPolicy(t, stringLoader(accessPolicy))
+	if policy == nil || policy.revision == "" {
+		t.Fatalf("policy not installed: %+v", policy)
+	}
+
+	rec := postEvaluate("/evaluate", `{"role": "admin"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	rec = postEvaluate("/evaluate", `{"role": "guest"}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("guest status = %d, want 403: %s", rec.Code, rec.Body)
+	}
+}
+
+// hangingLoader blocks until release is closed, then fails; with
+// honorContext it gives up as soon as its context is done.
+type hangingLoader struct {
+	release      chan struct{}
+	honorContext bool
+}
+
+func (l hangingLoader) Load(ctx context.Context) (string, error) {
+	if l.honorContext {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-l.release:
+		}
+	} else {
+		<-l.release
+	}
+	return "", errors.New("loader released")
+}
+
+func TestStartupLoadTimesOut(t *testing.T) {
+	for _, honor := range []bool{true, false} {
+		loader := hangingLoader{release: make(chan struct{}), honorContext: honor}
+		start := time.Now()
+		err := loadPolicyAtStartup(loader, 50*time.Millisecond)
+		close(loader.release)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("honorContext=%v: loadPolicyAtStartup() = %v, want a deadline error", honor, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("honorContext=%v: startup waited %v for a 50ms timeout", honor, elapsed)
+		}
+	}
+}
+
+func TestStartupLoadWithinTimeout(t *testing.T) {
+	previous := policies.Get()
+	t.Cleanup(func() { policies.Set(previous) })
+	if err := loadPolicyAtStartup(stringLoader(accessPolicy), time.Second); err != nil {
+		t.Fatalf("loadPolicyAtStartup() = %v", err)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "admin"}`); rec.Code != http.StatusOK {
+		t.Errorf("status after startup load = %d, want 200", rec.Code)
+	}
+}
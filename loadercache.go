@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cachingLoader caches the policy returned by another loader for ttl. Once
+// the entry is stale it is still returned while a single background fetch
+// refreshes it (stale-while-revalidate), so callers never wait on the inner
+// loader except for the very first load. A revalidation that finds a
+// changed policy installs it through loadAndPreparePolicy.
+type cachingLoader struct {
+	inner PolicyLoader
+	ttl   time.Duration
+
+	mu           sync.Mutex
+	policy       string
+	fetchedAt    time.Time
+	cached       bool
+	revalidating bool
+}
+
+func newCachingLoader(inner PolicyLoader, ttl time.Duration) *cachingLoader {
+	return &cachingLoader{inner: inner, ttl: ttl}
+}
+
+func (l *cachingLoader) Load(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	if !l.cached {
+		l.mu.Unlock()
+		return l.fetch(ctx)
+	}
+
+	policy := l.policy
+	if time.Since(l.fetchedAt) >= l.ttl && !l.revalidating {
+		l.revalidating = true
+		go l.revalidate()
+	}
+	l.mu.Unlock()
+	return policy, nil
+}
+
+// fetch loads from the inner loader and stores the result on success.
+func (l *cachingLoader) fetch(ctx context.Context) (string, error) {
+	policy, err := l.inner.Load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	l.policy = policy
+	l.fetchedAt = time.Now()
+	l.cached = true
+	l.mu.Unlock()
+	return policy, nil
+}
+
+// invalidate drops the cached policy, so the next Load waits for a fresh
+// copy. /reload uses it to always install the source's current policy.
+func (l *cachingLoader) invalidate() {
+	l.mu.Lock()
+	l.cached = false
+	l.mu.Unlock()
+}
+
+func (l *cachingLoader) revalidate() {
+	l.mu.Lock()
+	stale := l.policy
+	l.mu.Unlock()
+
+	policy, err := l.fetch(context.Background())
+
+	l.mu.Lock()
+	l.revalidating = false
+	l.mu.Unlock()
+
+	if err != nil {
+		sugar.Warnw("Failed to revalidate cached policy, serving stale copy", "error", err)
+		return
+	}
+	// The caller that triggered the revalidation compiled the stale copy.
+	if policy != stale {
+		if err := loadAndPreparePolicy(context.Background(), l); err != nil {
+			sugar.Errorw("Failed to install revalidated policy", "error", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// switchLoader serves a policy that tests can replace, optionally holding
+// each Load until gate is closed.
+type switchLoader struct {
+	mu     sync.Mutex
+	policy string
+	gate   chan struct{}
+	loads  int
+}
+
+func (l *switchLoader) set(policy string, gate chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.policy, l.gate = policy, gate
+}
+
+func (l *switchLoader) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loads
+}
+
+func (l *switchLoader) Load(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	policy, gate := l.policy, l.gate
+	l.loads++
+	l.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	return policy, nil
+}
+
+func TestCachingLoaderServesStaleWhileRevalidating(t *testing.T) {
+	source := &switchLoader{policy: accessPolicy}
+	cache := newCachingLoader(source, 10*time.Millisecond)
+	first := loadTestPolicy(t, cache)
+
+	adminsOnly := strings.Replace(accessPolicy, `input.role == "reader"`, `input.role == "nobody"`, 1)
+	gate := make(chan struct{})
+	source.set(adminsOnly, gate)
+	time.Sleep(20 * time.Millisecond)
+
+	// The stale copy is returned at once while one refresh waits on the source.
+	for i := 0; i < 3; i++ {
+		got, err := cache.Load(context.Background())
+		if err != nil || got != accessPolicy {
+			t.Fatalf("Load() during revalidation = %q, %v, want the stale policy", got, err)
+		}
+	}
+	waitFor(t, "the revalidation to reach the source", func() bool { return source.count() == 2 })
+	if got, err := cache.Load(context.Background()); err != nil || got != accessPolicy {
+		t.Fatalf("Load() while the source is blocked = %q, %v", got, err)
+	}
+	if got := source.count(); got != 2 {
+		t.Errorf("source loaded %d times, want one revalidation at a time", got)
+	}
+
+	close(gate)
+	waitFor(t, "the revalidated policy", func() bool { return policies.Get() != first })
+	if got, _ := cache.Load(context.Background()); got != adminsOnly {
+		t.Errorf("Load() after revalidation = %q, want the updated policy", got)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "reader", "action": "read"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("reader status after revalidation = %d, want 403 from the updated policy", rec.Code)
+	}
+}
+
+func TestCachingLoaderInvalidate(t *testing.T) {
+	source := &switchLoader{policy: accessPolicy}
+	cache := newCachingLoader(source, time.Hour)
+	if _, err := cache.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	source.set("updated", nil)
+	if got, _ := cache.Load(context.Background()); got != accessPolicy {
+		t.Errorf("Load() within the TTL = %q, want the cached policy", got)
+	}
+	cache.invalidate()
+	if got, _ := cache.Load(context.Background()); got != "updated" {
+		t.Errorf("Load() after invalidate = %q, want the source's policy", got)
+	}
+}
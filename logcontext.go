@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// contextWithLogger returns a copy of ctx carrying logger.
+func contextWithLogger(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFromContext returns the request-scoped logger stored in ctx, falling
+// back to the global logger.
+func loggerFromContext(ctx context.Context) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return sugar
+}
+
+// requestIDFromContext returns the request id stored by withRequestID, or
+// "" outside a request.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger derives a logger tagged with the caller's tenant (from the
+// X-Tenant-ID header) and the application the request concerns.
+func requestLogger(base *zap.SugaredLogger, r *http.Request, application string) *zap.SugaredLogger {
+	return base.With("tenant", r.Header.Get("X-Tenant-ID"), "application", application)
+}
+
+// requestIDHeader carries the id correlating a client's request with the
+// service's log lines.
+const requestIDHeader = "X-Request-ID"
+
+// withRequestID tags every request with the client's X-Request-ID, or a new
+// UUID when it sent none or an unusable one, echoes it in the response and
+// stores it, and a logger carrying it, on the request context.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = contextWithLogger(ctx, sugar.With("requestId", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts ids of up to 128 printable ASCII characters, so
+// clients cannot inject control characters into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
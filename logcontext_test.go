@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogsCarryTenantApplicationAndDecision(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "log.decisions", true)
+	logs := observeLogs(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(`{"role": "admin", "applicationName": "billing"}`))
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set(requestIDHeader, "req-42")
+	rec := httptest.NewRecorder()
+	withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		evaluatePolicyHandler(w, r, loggerFromContext(r.Context()))
+	})).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	entries := logs.FilterMessage("Decision").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d decision log lines, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	want := map[string]interface{}{
+		"tenant":      "acme",
+		"application": "billing",
+		"requestId":   "req-42",
+		"decisionId":  rec.Header().Get("X-Decision-ID"),
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("log field %s = %v, want %v", key, fields[key], value)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLevel is the level of the service logger, adjustable at runtime
+// through PUT /admin/loglevel.
+var logLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+// newLogger builds the service logger. Production logs never carry stack
+// traces; with `log.debug` the level drops to debug and internal errors
+// logged through logInternalError include one.
+func newLogger() (*zap.Logger, error) {
+	cfg := zap.NewProductionConfig()
+	cfg.DisableStacktrace = true
+	if viper.GetBool("log.debug") {
+		logLevel.SetLevel(zap.DebugLevel)
+	}
+	cfg.Level = logLevel
+	return cfg.Build()
+}
+
+// logLevelHandler reports the current log level on GET and changes it on
+// PUT with a body like {"level": "debug"}. The change lasts until the next
+// restart; stack traces still follow `log.debug`.
+func logLevelHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		level, err := zapcore.ParseLevel(body.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := logLevel.Level()
+		logLevel.SetLevel(level)
+		logger.Warnw("Log level changed", "from", previous.String(), "to", level.String(), "actor", requestActor(r))
+	default:
+		http.Error(w, "Only GET and PUT methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]string{"level": logLevel.Level().String()})
+}
+
+// logInternalError logs an unexpected server-side error, adding a stack
+// trace in debug mode to speed up diagnosis.
+func logInternalError(logger *zap.SugaredLogger, msg string, err error, keysAndValues ...interface{}) {
+	keysAndValues = append(keysAndValues, "error", err)
+	if viper.GetBool("log.debug") {
+		keysAndValues = append(keysAndValues, zap.Stack("stack"))
+	}
+	logger.Errorw(msg, keysAndValues...)
+}
+
+// decisionLog writes the audit line for one evaluation at info level. The
+// event's input is already redacted with `log.redactFields`. Set
+// `log.decisions` to false to turn these lines off in high-volume
+// deployments.
+func decisionLog(logger *zap.SugaredLogger, event decisionEvent, result interface{}) {
+	if !viper.GetBool("log.decisions") {
+		return
+	}
+	logger.Infow("Decision",
+		"timestamp", event.Timestamp,
+		"revision", event.Revision,
+		"allow", event.Allow,
+		"result", result,
+		"input", event.Input,
+	)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestInternalErrorStackOnlyInDebug(t *testing.T) {
+	logs := observeLogs(t)
+
+	setConfig(t, "log.debug", false)
+	logInternalError(sugar, "Failed in production", errors.New("boom"))
+	setConfig(t, "log.debug", true)
+	logInternalError(sugar, "Failed in debug", errors.New("boom"))
+
+	production := logs.FilterMessage("Failed in production").All()[0].ContextMap()
+	if _, ok := production["stack"]; ok {
+		t.Error("production log line carries a stack trace")
+	}
+	if production["error"] != "boom" {
+		t.Errorf("error field = %v, want boom", production["error"])
+	}
+
+	debug := logs.FilterMessage("Failed in debug").All()[0].ContextMap()
+	stack, _ := debug["stack"].(string)
+	if !strings.Contains(stack, "TestInternalErrorStackOnlyInDebug") {
+		t.Errorf("debug stack = %q, want the calling test in it", stack)
+	}
+}
+
+func TestNewLoggerLevelFollowsDebug(t *testing.T) {
+	setConfig(t, "log.debug", false)
+	previous := logLevel.Level()
+	logLevel.SetLevel(zapcore.InfoLevel)
+	t.Cleanup(func() { logLevel.SetLevel(previous) })
+
+	logger, err := newLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logger.Core().Enabled(zapcore.DebugLevel) {
+		t.Error("production logger enabled debug level")
+	}
+
+	setConfig(t, "log.debug", true)
+	if _, err := newLogger(); err != nil {
+		t.Fatal(err)
+	}
+	if logLevel.Level() != zapcore.DebugLevel {
+		t.Errorf("debug logger level = %s, want debug", logLevel.Level())
+	}
+}
+
+func putLogLevel(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	logLevelHandler(rec, httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(body)), sugar)
+	return rec
+}
+
+func TestLogLevelChangesAtRuntime(t *testing.T) {
+	previous := logLevel.Level()
+	logLevel.SetLevel(zapcore.InfoLevel)
+	t.Cleanup(func() { logLevel.SetLevel(previous) })
+	// A logger gated by logLevel, as newLogger builds.
+	core, logs := observer.New(logLevel)
+	logger := zap.New(core).Sugar()
+
+	logger.Debug("hidden at info")
+	if rec := putLogLevel(`{"level": "debug"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	logger.Debug("shown at debug")
+	putLogLevel(`{"level": "error"}`)
+	logger.Warn("hidden at error")
+
+	var messages []string
+	for _, entry := range logs.All() {
+		messages = append(messages, entry.Message)
+	}
+	if len(messages) != 1 || messages[0] != "shown at debug" {
+		t.Errorf("logged %q, want only the line written at debug", messages)
+	}
+
+	rec := httptest.NewRecorder()
+	logLevelHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil), sugar)
+	var got map[string]string
+	json.NewDecoder(rec.Body).Decode(&got)
+	if got["level"] != "error" {
+		t.Errorf("GET reported level %q, want error", got["level"])
+	}
+	if rec := putLogLevel(`{"level": "verbose"}`); rec.Code != http.StatusBadRequest || logLevel.Level() != zapcore.ErrorLevel {
+		t.Errorf("unknown level = %d, level now %s, want 400 and no change", rec.Code, logLevel.Level())
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMain(m *testing.M) {
+	initConfig()
+	sugar = zap.NewNop().Sugar()
+	registerMetrics()
+	os.Exit(m.Run())
+}
+
+// setConfig overrides key for the duration of the test.
+func setConfig(t *testing.T, key string, value interface{}) {
+	t.Helper()
+	old := viper.Get(key)
+	viper.Set(key, value)
+	t.Cleanup(func() { viper.Set(key, old) })
+}
+
+// stringLoader is a PolicyLoader serving a fixed policy.
+type stringLoader string
+
+func (l stringLoader) Load(ctx context.Context) (string, error) {
+	return string(l), nil
+}
+
+// bundleStub is a bundleLoader serving fixed modules and data.
+type bundleStub policySet
+
+func (b bundleStub) Load(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (b bundleStub) LoadBundle(ctx context.Context) (policySet, error) {
+	return policySet(b), nil
+}
+
+// loadTestPolicy installs the policy served by loader, restoring the
+// previous policy and bundle data when the test ends.
+func loadTestPolicy(t *testing.T, loader PolicyLoader) *loadedPolicy {
+	t.Helper()
+	previous := policies.Get()
+	t.Cleanup(func() {
+		policies.Set(previous)
+		if err := replaceBundleData(context.Background(), nil); err != nil {
+			t.Errorf("failed to clear bundle data: %v", err)
+		}
+	})
+	if err := loadAndPreparePolicy(context.Background(), loader); err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+	return policies.Get()
+}
+
+// accessPolicy allows admins, and readers when reading.
+const accessPolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if input.role == "admin"
+
+allow if {
+	input.role == "reader"
+	input.action == "read"
+}
+`
+
+// evaluateRequest runs req through evaluatePolicyHandler.
+func evaluateRequest(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	evaluatePolicyHandler(rec, req, sugar)
+	return rec
+}
+
+// postEvaluate sends body to /evaluate, target carrying any query string.
+func postEvaluate(target, body string) *httptest.ResponseRecorder {
+	return evaluateRequest(httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+}
+
+// fakeS3 is an in-memory, path-style S3 endpoint supporting the object and
+// listing calls the service makes.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	// puts records the headers of every PutObject call, by key.
+	puts map[string]http.Header
+	// gets counts GetObject calls, by key.
+	gets map[string]int
+	// delay holds every request before it is served; inFlight and
+	// maxInFlight track how many requests were being served at once.
+	delay                 time.Duration
+	inFlight, maxInFlight int
+	// conns counts the connections clients opened.
+	conns int
+}
+
+type fakeObject struct {
+	body     []byte
+	modified time.Time
+}
+
+// useFakeS3 points sharedS3Client at a fresh fakeS3 for the test, restoring
+// the client and the loaded policy ETag afterwards.
+func useFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	fake := &fakeS3{objects: map[string]fakeObject{}, puts: map[string]http.Header{}, gets: map[string]int{}}
+	srv := httptest.NewUnstartedServer(fake)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			fake.mu.Lock()
+			fake.conns++
+			fake.mu.Unlock()
+		}
+	}
+	srv.Start()
+	previous, etag := sharedS3Client, getLoadedETag()
+	t.Cleanup(func() {
+		sharedS3Client = previous
+		setLoadedETag(etag)
+		srv.Close()
+	})
+	sharedS3Client = newS3Client(aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+	return fake
+}
+
+// put stores body under key in the configured bucket.
+func (f *fakeS3) put(key, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = fakeObject{body: []byte(body), modified: time.Now().UTC().Truncate(time.Second)}
+}
+
+// object returns the body stored under key.
+func (f *fakeS3) object(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	return string(obj.body), ok
+}
+
+// uploadHeaders returns the headers of the last PutObject call for key.
+func (f *fakeS3) uploadHeaders(key string) http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.puts[key]
+}
+
+// downloads returns the number of GetObject calls served.
+func (f *fakeS3) downloads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, count := range f.gets {
+		n += count
+	}
+	return n
+}
+
+// connections returns the number of connections clients opened.
+func (f *fakeS3) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conns
+}
+
+// concurrency returns the most requests that were served at once.
+func (f *fakeS3) concurrency() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxInFlight
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	delay := f.delay
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+	time.Sleep(delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Path-style requests are /<bucket>/<key>.
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeObject{body: body, modified: time.Now().UTC().Truncate(time.Second)}
+		f.puts[key] = r.Header.Clone()
+		w.Header().Set("ETag", fakeETag(body))
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>%s</Key></Error>", key)
+			}
+			return
+		}
+		if r.Method == http.MethodGet {
+			f.gets[key]++
+		}
+		w.Header().Set("ETag", fakeETag(obj.body))
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+		if r.Method == http.MethodGet {
+			w.Write(obj.body)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "<ListBucketResult><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>", prefix, len(keys))
+	for _, key := range keys {
+		obj := f.objects[key]
+		fmt.Fprintf(&out, "<Contents><Key>%s</Key><Size>%d</Size><ETag>%s</ETag><LastModified>%s</LastModified></Contents>",
+			key, len(obj.body), fakeETag(obj.body), obj.modified.Format(time.RFC3339))
+	}
+	out.WriteString("</ListBucketResult>")
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(out.String()))
+}
+
+func fakeETag(body []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(body))
+}
+
+// observeLogs routes the global logger into an observer for the test.
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zap.DebugLevel)
+	previous := sugar
+	sugar = zap.New(core).Sugar()
+	t.Cleanup(func() { sugar = previous })
+	return logs
+}
+
+// waitFor polls cond until it holds, failing the test after two seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const metricsNamespace = "openpolicyservice"
+
+var (
+	generateRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "generate_policy_requests_total",
+		Help:      "Number of generate-policy requests received.",
+	})
+	generateRenderDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "generate_policy_render_duration_seconds",
+		Help:      "Time spent rendering the policy template.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	generateCompileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "generate_policy_compile_duration_seconds",
+		Help:      "Time spent compiling the rendered policy.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	generateUploadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "generate_policy_upload_duration_seconds",
+		Help:      "Time spent uploading the rendered policy to S3.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	generatePolicySize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "generate_policy_size_bytes",
+		Help:      "Size of rendered policies in bytes.",
+		Buckets:   prometheus.ExponentialBuckets(256, 2, 10),
+	})
+	policyLoadFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "policy_load_failures_total",
+		Help:      "Number of policy loads that failed to fetch or compile.",
+	})
+	policyReloadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "policy_reloads_total",
+		Help:      "Number of successful policy loads, including the initial one.",
+	})
+	policyLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "policy_last_reload_success_timestamp_seconds",
+		Help:      "Unix time of the last successful policy load.",
+	})
+	evaluationsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "evaluations_total",
+		Help:      "Number of policy evaluations, including failed ones.",
+	})
+	evaluationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "evaluation_duration_seconds",
+		Help:      "Time spent evaluating a policy query.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 15),
+	})
+	decisionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decisions_total",
+		Help:      "Number of successful evaluations by outcome: allow, deny, or other for non-boolean and undefined results.",
+	}, []string{"outcome"})
+	preparedQueryCacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "prepared_query_cache_hits_total",
+		Help:      "Number of evaluations, of the current or a pinned revision, that found a prepared query.",
+	})
+	preparedQueryCacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "prepared_query_cache_misses_total",
+		Help:      "Number of evaluations with no prepared query: no policy loaded, or a pinned revision no longer, or never, cached.",
+	})
+	preparedQueryCacheHitRatio = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "prepared_query_cache_hit_ratio",
+		Help:      "Share of evaluations that found a prepared query since startup, covering the current and pinned revisions.",
+	}, revisionCacheHitRatio)
+	evalQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "evaluate_queue_depth",
+		Help:      "Number of evaluations waiting for a concurrency slot.",
+	})
+	decisionsPublishedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decisions_published_total",
+		Help:      "Number of decision events published to the broker.",
+	})
+	decisionsDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decisions_dropped_total",
+		Help:      "Number of decision events dropped because the buffer was full or publishing failed.",
+	})
+)
+
+// registerMetrics registers the service collectors with the default
+// Prometheus registry served on /metrics.
+func registerMetrics() {
+	prometheus.MustRegister(
+		generateRequestsTotal,
+		generateRenderDuration,
+		generateCompileDuration,
+		generateUploadDuration,
+		generatePolicySize,
+		policyLoadFailuresTotal,
+		policyReloadsTotal,
+		policyLastReloadSuccess,
+		evaluationsTotal,
+		evaluationDuration,
+		decisionsTotal,
+		preparedQueryCacheHitsTotal,
+		preparedQueryCacheMissesTotal,
+		preparedQueryCacheHitRatio,
+		evalQueueDepth,
+		decisionsPublishedTotal,
+		decisionsDroppedTotal,
+	)
+}
+
+// metricsHandler serves the default registry. Scrapers sending
+// Accept: application/openmetrics-text get the OpenMetrics exposition
+// format, everyone else the classic Prometheus text format.
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+}
+
+// statsHandler summarizes the service's own metrics as JSON for dashboards
+// that do not scrape Prometheus. Counters and gauges map to their value,
+// histograms to their count and sum, and labelled metrics to an object keyed
+// by label value.
+func statsHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "GET" {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		logInternalError(logger, "Failed to gather metrics", err)
+		http.Error(w, "Failed to gather metrics", http.StatusInternalServerError)
+		return
+	}
+
+	stats := map[string]interface{}{}
+	prefix := metricsNamespace + "_"
+	for _, family := range families {
+		name, ok := strings.CutPrefix(family.GetName(), prefix)
+		if !ok {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			value := metricValue(family.GetType(), m)
+			if len(m.GetLabel()) == 0 {
+				stats[name] = value
+				continue
+			}
+			byLabel, _ := stats[name].(map[string]interface{})
+			if byLabel == nil {
+				byLabel = map[string]interface{}{}
+				stats[name] = byLabel
+			}
+			labels := make([]string, 0, len(m.GetLabel()))
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetValue())
+			}
+			byLabel[strings.Join(labels, ",")] = value
+		}
+	}
+	writeJSON(w, r, http.StatusOK, stats)
+}
+
+func metricValue(kind dto.MetricType, m *dto.Metric) interface{} {
+	switch kind {
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return m.GetGauge().GetValue()
+	case dto.MetricType_HISTOGRAM:
+		return map[string]interface{}{
+			"count": m.GetHistogram().GetSampleCount(),
+			"sum":   m.GetHistogram().GetSampleSum(),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// readMetric returns the current state of a single-series metric.
+func readMetric(t *testing.T, m prometheus.Metric) *dto.Metric {
+	t.Helper()
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	return &out
+}
+
+// samplePolicyData is a PolicyData that renders to a valid policy.
+const samplePolicyData = `{
+	"ApplicationName": "billing",
+	"Environment": "prod",
+	"ClientID": "client-1",
+	"ApiName": "invoices",
+	"ApiVersion": "v1",
+	"AllowedActions": ["read"],
+	"AllowedAttributes": ["amount"]
+}`
+
+// generateDryRun posts body to /generate-policy without uploading.
+func generateDryRun(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/generate-policy?dryRun=true", strings.NewReader(body))
+	generatePolicyHandler(rec, req, sugar)
+	return rec
+}
+
+func TestGeneratePolicySizeMetric(t *testing.T) {
+	requests := readMetric(t, generateRequestsTotal).GetCounter().GetValue()
+	before := readMetric(t, generatePolicySize).GetHistogram()
+	renders := readMetric(t, generateRenderDuration).GetHistogram().GetSampleCount()
+	compiles := readMetric(t, generateCompileDuration).GetHistogram().GetSampleCount()
+
+	rec := generateDryRun(samplePolicyData)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	after := readMetric(t, generatePolicySize).GetHistogram()
+	if got := after.GetSampleCount() - before.GetSampleCount(); got != 1 {
+		t.Fatalf("size histogram observed %d samples, want 1", got)
+	}
+	if got, want := after.GetSampleSum()-before.GetSampleSum(), float64(rec.Body.Len()); got != want {
+		t.Errorf("size histogram observed %v bytes, want the rendered length %v", got, want)
+	}
+	if got := readMetric(t, generateRequestsTotal).GetCounter().GetValue() - requests; got != 1 {
+		t.Errorf("request counter advanced by %v, want 1", got)
+	}
+	if readMetric(t, generateRenderDuration).GetHistogram().GetSampleCount() != renders+1 {
+		t.Error("render duration not observed")
+	}
+	if readMetric(t, generateCompileDuration).GetHistogram().GetSampleCount() != compiles+1 {
+		t.Error("compile duration not observed")
+	}
+}
+
+func TestMetricsContentNegotiation(t *testing.T) {
+	handler := metricsHandler()
+	scrape := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := scrape("application/openmetrics-text; version=1.0.0")
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/openmetrics-text") {
+		t.Errorf("OpenMetrics Content-Type = %q", got)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Error("OpenMetrics body lacks the # EOF trailer")
+	}
+	if !strings.Contains(rec.Body.String(), "generate_policy_requests_total") {
+		t.Error("service metrics missing from the OpenMetrics exposition")
+	}
+
+	rec = scrape("")
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("default Content-Type = %q, want the Prometheus text format", got)
+	}
+	if strings.Contains(rec.Body.String(), "# EOF") {
+		t.Error("Prometheus text body has an OpenMetrics trailer")
+	}
+}
+
+// fetchStats returns the /stats document.
+func fetchStats(t *testing.T) map[string]interface{} {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil), sugar)
+	var stats map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("/stats is not JSON: %v", err)
+	}
+	return stats
+}
+
+func TestStatsReflectActivity(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	before := fetchStats(t)
+	decisionCount := func(stats map[string]interface{}, outcome string) float64 {
+		byOutcome, _ := stats["decisions_total"].(map[string]interface{})
+		n, _ := byOutcome[outcome].(float64)
+		return n
+	}
+
+	postEvaluate("/evaluate", `{"role": "admin"}`)
+	postEvaluate("/evaluate", `{"role": "guest"}`)
+	postEvaluate("/evaluate", `{"role": "guest"}`)
+
+	after := fetchStats(t)
+	if got := after["evaluations_total"].(float64) - before["evaluations_total"].(float64); got != 3 {
+		t.Errorf("evaluations_total advanced by %v, want 3", got)
+	}
+	if got := decisionCount(after, "allow") - decisionCount(before, "allow"); got != 1 {
+		t.Errorf("allow decisions advanced by %v, want 1", got)
+	}
+	if got := decisionCount(after, "deny") - decisionCount(before, "deny"); got != 2 {
+		t.Errorf("deny decisions advanced by %v, want 2", got)
+	}
+	duration, _ := after["evaluation_duration_seconds"].(map[string]interface{})
+	if duration["count"] == nil || duration["sum"] == nil {
+		t.Errorf("evaluation_duration_seconds = %v, want its count and sum", after["evaluation_duration_seconds"])
+	}
+	if _, ok := after["go_goroutines"]; ok {
+		t.Error("runtime metrics outside the service namespace included")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/spf13/viper"
+)
+
+// splitDataOverrides recognizes an evaluate body of the form
+// {"input": {...}, "data": {...}} and returns its parts. Any other body is
+// the input itself and yields nil overrides.
+func splitDataOverrides(body map[string]interface{}) (input, data map[string]interface{}) {
+	if len(body) != 2 {
+		return body, nil
+	}
+	in, inOK := body["input"].(map[string]interface{})
+	d, dataOK := body["data"].(map[string]interface{})
+	if !inOK || !dataOK {
+		return body, nil
+	}
+	return in, d
+}
+
+// checkDataOverrides rejects overrides the request cannot honour. They are
+// evaluated against the current revision's allow query with the
+// interpreter, so pinning a revision, selecting a query or a Wasm target
+// would silently be ignored. Overrides count towards the input size limits.
+func checkDataOverrides(r *http.Request, overrides map[string]interface{}) error {
+	if r.URL.Query().Get("revision") != "" {
+		return errors.New("data overrides cannot be combined with a pinned revision")
+	}
+	if path := r.URL.Query().Get("query"); path != "" && path != allowQuery() {
+		return errors.New("data overrides cannot be combined with a selected query")
+	}
+	if viper.GetString("policy.target") == "wasm" {
+		return errors.New("data overrides are not supported with the wasm target")
+	}
+	return checkInputLimits(overrides, viper.GetInt("evaluate.maxDepth"), viper.GetInt("evaluate.maxKeys"))
+}
+
+// overridePolicy returns a copy of policy whose queries, the auxiliary ones
+// included, read the current data with overrides deep-merged over it. The
+// queries are compiled for this request only, so the shared prepared
+// queries and dataStore are never modified; that cost is why overrides are
+// opt-in through `evaluate.dataOverrides`.
+func overridePolicy(ctx context.Context, policy *loadedPolicy, overrides map[string]interface{}) (*loadedPolicy, error) {
+	data, err := dataSnapshot(ctx, policy.data)
+	if err != nil {
+		return nil, err
+	}
+	mergeData(data, overrides)
+	store := rego.Store(inmem.NewFromObject(data))
+
+	query, err := prepareQuery(ctx, allowQuery(), policy.modules, store)
+	if err != nil {
+		return nil, err
+	}
+	overridden := &loadedPolicy{
+		query:            &query,
+		interpretedQuery: &query,
+		modules:          policy.modules,
+		data:             policy.data,
+		revision:         policy.revision,
+	}
+	if err := prepareAuxiliaryQueries(ctx, overridden, store); err != nil {
+		return nil, err
+	}
+	return overridden, nil
+}
+
+// mergeData deep-merges src into dst: objects present in both are merged
+// key by key, any other value from src replaces the one in dst.
+func mergeData(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcObj, srcOK := value.(map[string]interface{})
+		dstObj, dstOK := dst[key].(map[string]interface{})
+		if srcOK && dstOK {
+			mergeData(dstObj, srcObj)
+			continue
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// rolesPolicy allows users that data.roles lists as admins.
+const rolesPolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if data.roles[input.user] == "admin"
+`
+
+func loadRolesPolicy(t *testing.T) {
+	t.Helper()
+	loadTestPolicy(t, bundleStub{
+		modules: map[string]string{"roles.rego": rolesPolicy},
+		data:    map[string]interface{}{"roles": map[string]interface{}{"alice": "admin", "bob": "reader"}},
+	})
+}
+
+func TestDataOverrideFlipsOneDecision(t *testing.T) {
+	loadRolesPolicy(t)
+	setConfig(t, "evaluate.dataOverrides", true)
+
+	overridden := `{"input": {"user": "bob"}, "data": {"roles": {"bob": "admin"}}}`
+	if rec := postEvaluate("/evaluate", overridden); rec.Code != http.StatusOK {
+		t.Errorf("overridden request status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := postEvaluate("/evaluate", `{"user": "bob"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("status after the override = %d, want 403: the override leaked into the store", rec.Code)
+	}
+
+	// Overrides are deep-merged, so roles they do not mention are kept.
+	merged := `{"input": {"user": "alice"}, "data": {"roles": {"bob": "admin"}}}`
+	if rec := postEvaluate("/evaluate", merged); rec.Code != http.StatusOK {
+		t.Errorf("alice status with an unrelated override = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDataOverridesDisabledByDefault(t *testing.T) {
+	loadRolesPolicy(t)
+	setConfig(t, "evaluate.dataOverrides", false)
+
+	rec := postEvaluate("/evaluate", `{"input": {"user": "bob"}, "data": {"roles": {"bob": "admin"}}}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403 with the body taken as the input", rec.Code)
+	}
+}
+
+func TestDataOverridesRejectedWithPinsAndQueries(t *testing.T) {
+	loadRolesPolicy(t)
+	setConfig(t, "evaluate.dataOverrides", true)
+	body := `{"input": {"user": "bob"}, "data": {"roles": {"bob": "admin"}}}`
+
+	for _, target := range []string{"/evaluate?revision=abc", "/evaluate?query=data.api.access.other"} {
+		rec := postEvaluate(target, body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "data overrides cannot be combined") {
+			t.Errorf("%s: got %d %q, want 400", target, rec.Code, rec.Body)
+		}
+	}
+
+	setConfig(t, "evaluate.maxKeys", 3)
+	rec := postEvaluate("/evaluate", `{"input": {"user": "bob"}, "data": {"roles": {"a": 1, "b": 2, "c": 3}}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("oversized overrides status = %d, want 400", rec.Code)
+	}
+}
+
+func TestMergeData(t *testing.T) {
+	dst := map[string]interface{}{
+		"roles":  map[string]interface{}{"alice": "admin", "bob": "reader"},
+		"limits": []interface{}{1, 2},
+	}
+	mergeData(dst, map[string]interface{}{
+		"roles":  map[string]interface{}{"bob": "admin"},
+		"limits": []interface{}{3},
+	})
+	roles := dst["roles"].(map[string]interface{})
+	if roles["alice"] != "admin" || roles["bob"] != "admin" {
+		t.Errorf("roles = %v, want alice kept and bob replaced", roles)
+	}
+	if limits := dst["limits"].([]interface{}); len(limits) != 1 {
+		t.Errorf("limits = %v, want arrays replaced rather than merged", limits)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// inputTransform is one step of the `evaluate.transforms` pipeline. Which
+// fields are used depends on Op:
+//
+//	rename  top-level field From becomes To; as with evaluate.fieldAliases,
+//	        a To the client already sent wins and From is dropped
+//	redact  Field is replaced by redactedValue
+//	coerce  Field is converted to Type, as in evaluate.inputTypes
+//	default Field is set to Value when the client did not send it
+//
+// Field is dot-separated for nested fields.
+type inputTransform struct {
+	Op    string      `mapstructure:"op"`
+	From  string      `mapstructure:"from"`
+	To    string      `mapstructure:"to"`
+	Field string      `mapstructure:"field"`
+	Type  string      `mapstructure:"type"`
+	Value interface{} `mapstructure:"value"`
+}
+
+// inputTransforms is applied, in order, to every /evaluate input after
+// aliasing and before type coercion.
+var inputTransforms []inputTransform
+
+// loadInputTransforms reads and checks `evaluate.transforms`. Default values
+// are normalized through JSON so the policy sees the same types it would for
+// a client-sent value.
+func loadInputTransforms() error {
+	var transforms []inputTransform
+	if err := viper.UnmarshalKey("evaluate.transforms", &transforms); err != nil {
+		return fmt.Errorf("failed to read evaluate.transforms: %w", err)
+	}
+	for i, t := range transforms {
+		switch t.Op {
+		case "rename":
+			if t.From == "" || t.To == "" {
+				return fmt.Errorf("evaluate.transforms[%d]: rename needs both from and to", i)
+			}
+			continue
+		case "redact", "default":
+		case "coerce":
+			switch t.Type {
+			case "bool", "number", "string":
+			default:
+				return fmt.Errorf("evaluate.transforms[%d]: unsupported type %q", i, t.Type)
+			}
+		default:
+			return fmt.Errorf("evaluate.transforms[%d]: unknown op %q", i, t.Op)
+		}
+		if t.Field == "" {
+			return fmt.Errorf("evaluate.transforms[%d]: %s needs a field", i, t.Op)
+		}
+		if t.Op == "default" {
+			raw, err := json.Marshal(t.Value)
+			if err != nil {
+				return fmt.Errorf("evaluate.transforms[%d]: invalid default value: %w", i, err)
+			}
+			if err := json.Unmarshal(raw, &transforms[i].Value); err != nil {
+				return fmt.Errorf("evaluate.transforms[%d]: invalid default value: %w", i, err)
+			}
+		}
+	}
+	inputTransforms = transforms
+	return nil
+}
+
+// transformInput runs the pipeline over a copy of input. A failing coerce
+// step is reported as an error; the other steps cannot fail.
+func transformInput(input map[string]interface{}) (map[string]interface{}, error) {
+	if len(inputTransforms) == 0 {
+		return input, nil
+	}
+
+	out := copyMap(input)
+	for _, t := range inputTransforms {
+		switch t.Op {
+		case "rename":
+			if v, ok := out[t.From]; ok {
+				delete(out, t.From)
+				if _, exists := out[t.To]; !exists {
+					out[t.To] = v
+				}
+			}
+		case "redact":
+			redactPath(out, strings.Split(t.Field, "."))
+		case "coerce":
+			if err := coercePath(out, strings.Split(t.Field, "."), t.Type); err != nil {
+				return nil, fmt.Errorf("input field %s: %w", t.Field, err)
+			}
+		case "default":
+			defaultPath(out, strings.Split(t.Field, "."), t.Value)
+		}
+	}
+	return out, nil
+}
+
+// defaultPath sets the field at path to value unless it is already present,
+// creating intermediate objects as needed. A non-object in the way leaves
+// the input unchanged.
+func defaultPath(m map[string]interface{}, path []string, value interface{}) {
+	v, ok := m[path[0]]
+	if len(path) == 1 {
+		if !ok {
+			m[path[0]] = value
+		}
+		return
+	}
+	if !ok {
+		v = map[string]interface{}{}
+	}
+	if child, ok := v.(map[string]interface{}); ok {
+		child = copyMap(child)
+		m[path[0]] = child
+		defaultPath(child, path[1:], value)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// useInputTransforms loads transforms as `evaluate.transforms` for the test.
+func useInputTransforms(t *testing.T, transforms []map[string]interface{}) {
+	t.Helper()
+	previous := inputTransforms
+	t.Cleanup(func() { inputTransforms = previous })
+	setConfig(t, "evaluate.transforms", transforms)
+	if err := loadInputTransforms(); err != nil {
+		t.Fatalf("failed to load transforms: %v", err)
+	}
+}
+
+func TestInputPipelineProducesEvaluatedInput(t *testing.T) {
+	useInputTransforms(t, []map[string]interface{}{
+		{"op": "rename", "from": "userRole", "to": "role"},
+		{"op": "redact", "field": "user.ssn"},
+		{"op": "coerce", "field": "user.level", "type": "number"},
+		{"op": "default", "field": "action", "value": "read"},
+		{"op": "default", "field": "user.region", "value": "eu"},
+	})
+
+	var input map[string]interface{}
+	json.Unmarshal([]byte(`{"userRole": "reader", "user": {"ssn": "123-45-6789", "level": "3"}}`), &input)
+	got, err := transformInput(input)
+	if err != nil {
+		t.Fatalf("transformInput() = %v", err)
+	}
+	want := map[string]interface{}{
+		"role":   "reader",
+		"action": "read",
+		"user":   map[string]interface{}{"ssn": redactedValue, "level": 3.0, "region": "eu"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transformed input = %v, want %v", got, want)
+	}
+	if user := input["user"].(map[string]interface{}); user["ssn"] != "123-45-6789" {
+		t.Error("the pipeline modified the client's input")
+	}
+
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	if rec := postEvaluate("/evaluate", `{"userRole": "reader"}`); rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 for a renamed role with the default action", rec.Code)
+	}
+}
+
+func TestPipelineRenameKeepsExplicitTarget(t *testing.T) {
+	useInputTransforms(t, []map[string]interface{}{{"op": "rename", "from": "userRole", "to": "role"}})
+	got, _ := transformInput(map[string]interface{}{"userRole": "admin", "role": "guest"})
+	if !reflect.DeepEqual(got, map[string]interface{}{"role": "guest"}) {
+		t.Errorf("transformed input = %v, want the explicit role kept and userRole dropped", got)
+	}
+}
+
+func TestLoadInputTransformsRejectsBadSteps(t *testing.T) {
+	previous := inputTransforms
+	t.Cleanup(func() { inputTransforms = previous })
+	for _, step := range []map[string]interface{}{
+		{"op": "rename", "from": "a"},
+		{"op": "coerce", "field": "a", "type": "date"},
+		{"op": "uppercase", "field": "a"},
+		{"op": "redact"},
+	} {
+		setConfig(t, "evaluate.transforms", []map[string]interface{}{step})
+		if err := loadInputTransforms(); err == nil {
+			t.Errorf("step %v accepted", step)
+		}
+	}
+}
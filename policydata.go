@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// safeName matches the names that make up a policy's object key, keeping
+// keys free of path separators and control characters.
+var safeName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// sanitizeKeyComponent rejects values that are unsafe to interpolate into
+// an S3 object key: anything outside safeName, which covers slashes,
+// backslashes and control characters, and any ".." sequence that could
+// climb out of the policies/ prefix.
+func sanitizeKeyComponent(value string) error {
+	switch {
+	case value == "":
+		return errors.New("is required")
+	case !safeName.MatchString(value):
+		return errors.New("may only contain letters, digits, '.', '_' and '-'")
+	case strings.Contains(value, ".."):
+		return errors.New("may not contain '..'")
+	}
+	return nil
+}
+
+// Validate checks the fields that identify a policy and returns one message
+// per invalid field, or nil when policyData can be used to build an object
+// key and rendered. Environment and ClientID are not part of the key, but
+// the template places them inside Rego strings, where a quote would let a
+// client inject rules.
+func (p PolicyData) Validate() []string {
+	return validateFields([]policyField{
+		{"ApplicationName", p.ApplicationName},
+		{"Environment", p.Environment},
+		{"ClientID", p.ClientID},
+		{"ApiName", p.ApiName},
+		{"ApiVersion", p.ApiVersion},
+	})
+}
+
+// validateKey checks only the fields that make up the object key, for
+// requests that name an existing policy rather than describe a new one.
+func (p PolicyData) validateKey() []string {
+	return validateFields([]policyField{
+		{"ApplicationName", p.ApplicationName},
+		{"ApiName", p.ApiName},
+		{"ApiVersion", p.ApiVersion},
+	})
+}
+
+// policyField is a named PolicyData value checked by validateFields.
+type policyField struct{ name, value string }
+
+// validateFields runs sanitizeKeyComponent over each field.
+func validateFields(fields []policyField) []string {
+	var errs []string
+	for _, field := range fields {
+		if err := sanitizeKeyComponent(field.value); err != nil {
+			errs = append(errs, field.name+" "+err.Error())
+		}
+	}
+	return errs
+}
+
+// policyDataKey returns the key of the sidecar object holding the PolicyData
+// a policy was generated from.
+func policyDataKey(objectKey string) string {
+	return objectKey + ".json"
+}
+
+// uploadPolicyData stores policyData next to the generated policy so it can
+// be patched and re-rendered later.
+func uploadPolicyData(ctx context.Context, s3Client *s3.Client, objectKey string, policyData PolicyData) error {
+	body, err := json.Marshal(policyData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy data: %w", err)
+	}
+
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(viper.GetString("s3.bucketName")),
+		Key:         aws.String(policyDataKey(objectKey)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// errPolicyDataNotFound is returned when a policy has no PolicyData sidecar.
+var errPolicyDataNotFound = errors.New("policy data not found")
+
+// fetchPolicyData loads the PolicyData sidecar for objectKey.
+func fetchPolicyData(ctx context.Context, s3Client *s3.Client, objectKey string) (PolicyData, error) {
+	var policyData PolicyData
+
+	resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(viper.GetString("s3.bucketName")),
+		Key:    aws.String(policyDataKey(objectKey)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return policyData, errPolicyDataNotFound
+		}
+		return policyData, fmt.Errorf("failed to get policy data from S3: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return policyData, fmt.Errorf("failed to read policy data: %w", err)
+	}
+	if err := json.Unmarshal(body, &policyData); err != nil {
+		return policyData, fmt.Errorf("failed to decode policy data: %w", err)
+	}
+	return policyData, nil
+}
+
+// patchPolicyHandler merges the fields present in the request body over the
+// stored PolicyData of an existing policy, then re-renders and re-uploads it.
+// ApplicationName, ApiName and ApiVersion identify the policy to patch.
+func patchPolicyHandler(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Only PATCH method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var target PolicyData
+	if err := json.Unmarshal(body, &target); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if errs := target.validateKey(); errs != nil {
+		writeJSON(w, r, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+		return
+	}
+
+	ctx := context.Background()
+	objectKey := policyObjectKey(target)
+	current, err := fetchPolicyData(ctx, sharedS3Client, objectKey)
+	if errors.Is(err, errPolicyDataNotFound) {
+		http.Error(w, "Policy not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logInternalError(sugar, "Failed to fetch policy data", err, "objectKey", objectKey)
+		http.Error(w, "Failed to fetch policy data", http.StatusInternalServerError)
+		return
+	}
+
+	// Unmarshalling over the stored value only replaces the fields the client sent.
+	if err := json.Unmarshal(body, &current); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if errs := current.Validate(); errs != nil {
+		writeJSON(w, r, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+		return
+	}
+
+	generateRequestsTotal.Inc()
+	publishPolicy(w, r, current, requestLogger(sugar, r, current.ApplicationName))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const billingPolicyKey = "policies/billing_invoices_v1.rego"
+
+// patchPolicy sends body to /patch-policy.
+func patchPolicy(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	patchPolicyHandler(rec, httptest.NewRequest(http.MethodPatch, "/patch-policy", strings.NewReader(body)), sugar)
+	return rec
+}
+
+func TestPatchPolicyAllowedActions(t *testing.T) {
+	fake := useFakeS3(t)
+	fake.put(policyDataKey(billingPolicyKey), samplePolicyData)
+
+	rec := patchPolicy(`{"ApplicationName": "billing", "ApiName": "invoices", "ApiVersion": "v1", "AllowedActions": ["read", "write"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	sidecar, _ := fake.object(policyDataKey(billingPolicyKey))
+	var stored PolicyData
+	if err := json.Unmarshal([]byte(sidecar), &stored); err != nil {
+		t.Fatalf("stored policy data is not JSON: %v", err)
+	}
+	if want := []string{"read", "write"}; !reflect.DeepEqual(stored.AllowedActions, want) {
+		t.Errorf("AllowedActions = %v, want %v", stored.AllowedActions, want)
+	}
+	if stored.Environment != "prod" || stored.ClientID != "client-1" || !reflect.DeepEqual(stored.AllowedAttributes, []string{"amount"}) {
+		t.Errorf("fields missing from the patch were not kept: %+v", stored)
+	}
+
+	policy, ok := fake.object(billingPolicyKey)
+	if !ok || !strings.Contains(policy, `\"write\"`) || !strings.Contains(policy, `input.environment == "prod"`) {
+		t.Errorf("re-rendered policy lacks the patched actions:\n%s", policy)
+	}
+}
+
+func TestPatchPolicyNotFound(t *testing.T) {
+	useFakeS3(t)
+	rec := patchPolicy(`{"ApplicationName": "billing", "ApiName": "invoices", "ApiVersion": "v1"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPolicyDataValidateRejectsUnsafeFields(t *testing.T) {
+	var data PolicyData
+	if err := json.Unmarshal([]byte(samplePolicyData), &data); err != nil {
+		t.Fatal(err)
+	}
+	if errs := data.Validate(); errs != nil {
+		t.Fatalf("valid policy data rejected: %v", errs)
+	}
+
+	data.ApiName = "../secrets"
+	data.ClientID = `x" } allow if { true`
+	errs := data.Validate()
+	if len(errs) != 2 || !strings.HasPrefix(errs[0], "ClientID") || !strings.HasPrefix(errs[1], "ApiName") {
+		t.Errorf("Validate() = %v, want ClientID and ApiName errors", errs)
+	}
+}
+
+func TestGenerateRejectsInvalidPolicyData(t *testing.T) {
+	fake := useFakeS3(t)
+	rec := httptest.NewRecorder()
+	body := `{"ApplicationName": "billing", "ApiName": "invoices", "ApiVersion": "v1"}`
+	generatePolicyHandler(rec, httptest.NewRequest(http.MethodPost, "/generate-policy", strings.NewReader(body)), sugar)
+	var resp struct {
+		Errors []string `json:"errors"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusBadRequest || len(resp.Errors) != 2 {
+		t.Errorf("generate = %d %s, want 400 naming Environment and ClientID", rec.Code, rec.Body)
+	}
+	if _, ok := fake.object(billingPolicyKey); ok {
+		t.Error("invalid policy data was uploaded")
+	}
+}
+
+func TestSanitizeKeyComponent(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"invoices":        true,
+		"v1.2":            true,
+		"billing_api-x":   true,
+		"":                false,
+		"..":              false,
+		"../../prod":      false,
+		"prod/policy":     false,
+		`prod\policy`:     false,
+		"a..b":            false,
+		"line\nbreak":     false,
+		"nul\x00":         false,
+		"%2e%2e%2fpolicy": false,
+	} {
+		if err := sanitizeKeyComponent(value); (err == nil) != ok {
+			t.Errorf("sanitizeKeyComponent(%q) = %v, want ok=%v", value, err, ok)
+		}
+	}
+}
+
+func TestGenerateRejectsTraversalKeys(t *testing.T) {
+	fake := useFakeS3(t)
+	for _, field := range []string{"ApplicationName", "ApiName", "ApiVersion"} {
+		var data map[string]interface{}
+		json.Unmarshal([]byte(samplePolicyData), &data)
+		data[field] = "../../prod/policy"
+		body, _ := json.Marshal(data)
+
+		rec := httptest.NewRecorder()
+		generatePolicyHandler(rec, httptest.NewRequest(http.MethodPost, "/generate-policy", strings.NewReader(string(body))), sugar)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), field) {
+			t.Errorf("%s traversal: generate = %d %s, want 400 naming the field", field, rec.Code, rec.Body)
+		}
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.objects) != 0 {
+		t.Errorf("traversal attempts stored %d objects", len(fake.objects))
+	}
+}
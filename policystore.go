@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// loadedPolicy is everything prepared from one policy load. Reloads replace
+// it as a whole, so a request never mixes queries from two revisions.
+type loadedPolicy struct {
+	// The allow query, compiled for `policy.target`
+	query *rego.PreparedEvalQuery
+	// The allow query prepared for the interpreter, which /explain traces;
+	// the same query as above unless the target is wasm
+	interpretedQuery *rego.PreparedEvalQuery
+	// Rego sources the queries were compiled from, keyed by module name
+	modules map[string]string
+	// Data documents shipped with the modules
+	data map[string]interface{}
+	// Content hash of modules
+	revision string
+	// Optional query returning the scopes a denied request needs
+	scopesQuery *rego.PreparedEvalQuery
+	// Query returning the justification for a decision
+	reasonQuery *rego.PreparedEvalQuery
+	// Optional query returning the set of human-readable denial reasons
+	denyQuery *rego.PreparedEvalQuery
+	// Optional query returning why a request was denied, e.g. "geo_blocked"
+	denialCategoryQuery *rego.PreparedEvalQuery
+	// Optional query returning the attributes an allowed caller may see,
+	// either one list or a list per role
+	attributesQuery *rego.PreparedEvalQuery
+	// Optional query that is true when a request is outside the policy's scope
+	notApplicableQuery *rego.PreparedEvalQuery
+	// Optional query returning how many seconds a decision may be cached
+	cacheTTLQuery *rego.PreparedEvalQuery
+	// Queries clients may select with ?query=, keyed by query path
+	selectable map[string]*rego.PreparedEvalQuery
+}
+
+// policyStore holds the current loadedPolicy, safe for concurrent use by
+// handlers and background reloads.
+type policyStore struct {
+	mu      sync.RWMutex
+	current *loadedPolicy
+}
+
+// policies is the policy the service evaluates against.
+var policies policyStore
+
+// Get returns the current policy, or nil if none has loaded yet.
+func (s *policyStore) Get() *loadedPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Set makes p the current policy.
+func (s *policyStore) Set(p *loadedPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = p
+}
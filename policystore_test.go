@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEvaluateWithoutPolicy(t *testing.T) {
+	previous := policies.Get()
+	t.Cleanup(func() { policies.Set(previous) })
+	policies.Set(nil)
+
+	rec := postEvaluate("/evaluate", `{"role": "admin"}`)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(strings.ToLower(rec.Body.String()), "policy not loaded") {
+		t.Errorf("got %d %q, want 503 policy not loaded", rec.Code, rec.Body)
+	}
+}
+
+func TestConcurrentEvaluationsDuringReload(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	// The reloaded revision denies readers, so each answer must come
+	// wholly from one revision or the other.
+	adminOnly := strings.Replace(accessPolicy, `input.role == "reader"`, `input.role == "nobody"`, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if rec := postEvaluate("/evaluate", `{"role": "admin"}`); rec.Code != http.StatusOK {
+					t.Errorf("admin status = %d during reload", rec.Code)
+					return
+				}
+				if rec := postEvaluate("/evaluate", `{"role": "reader", "action": "read"}`); rec.Code != http.StatusOK && rec.Code != http.StatusForbidden {
+					t.Errorf("reader status = %d during reload", rec.Code)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		src := accessPolicy
+		if i%2 == 0 {
+			src = adminOnly
+		}
+		if err := loadAndPreparePolicy(context.Background(), stringLoader(src)); err != nil {
+			t.Fatalf("reload %d failed: %v", i, err)
+		}
+	}
+	wg.Wait()
+}
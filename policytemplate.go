@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// policyTemplates caches the parsed policy template between generate
+// requests. It is filled on first use and replaced by reloadPolicyTemplate.
+var policyTemplates struct {
+	sync.Mutex
+	tmpl *template.Template
+}
+
+// parsePolicyTemplate reads and parses `policy.templatePath`. Templates may
+// call jsonMarshal to embed a value as a Rego string holding its JSON, e.g.
+// json.unmarshal({{ jsonMarshal .AllowedActions }}).
+func parsePolicyTemplate() (*template.Template, error) {
+	path := viper.GetString("policy.templatePath")
+	if err := checkTemplatePath(path, viper.GetString("policy.templateRoot")); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil, fmt.Errorf("policy.templatePath %q is a directory, set it to the template file itself", path)
+	}
+	templateBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy template file: %w", err)
+	}
+	tmpl, err := template.New("policy").Funcs(template.FuncMap{"jsonMarshal": jsonMarshal}).Parse(string(templateBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// checkTemplatePath rejects template paths outside root, so templates can
+// only be read from the configured directory. Symlinks are resolved first,
+// so a link inside root cannot point elsewhere. An empty root allows any
+// path.
+func checkTemplatePath(path, root string) error {
+	if root == "" {
+		return nil
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("invalid policy.templateRoot %q: %w", root, err)
+	}
+	if resolved, err := filepath.EvalSymlinks(absRoot); err == nil {
+		absRoot = resolved
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("invalid policy.templatePath %q: %w", path, err)
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		absPath = resolved
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("policy.templatePath %q is outside policy.templateRoot %q", path, root)
+	}
+	return nil
+}
+
+// cachedPolicyTemplate returns the cached policy template, parsing it first
+// if no generate request has needed it yet.
+func cachedPolicyTemplate() (*template.Template, error) {
+	policyTemplates.Lock()
+	defer policyTemplates.Unlock()
+	if policyTemplates.tmpl == nil {
+		tmpl, err := parsePolicyTemplate()
+		if err != nil {
+			return nil, err
+		}
+		policyTemplates.tmpl = tmpl
+	}
+	return policyTemplates.tmpl, nil
+}
+
+// reloadPolicyTemplate re-reads the policy template. On failure the cached
+// template is kept.
+func reloadPolicyTemplate() error {
+	tmpl, err := parsePolicyTemplate()
+	if err != nil {
+		return err
+	}
+	policyTemplates.Lock()
+	policyTemplates.tmpl = tmpl
+	policyTemplates.Unlock()
+	return nil
+}
+
+// reloadTemplateHandler re-reads the policy template so template changes
+// apply to the next generate request without a restart.
+func reloadTemplateHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := reloadPolicyTemplate(); err != nil {
+		logger.Errorw("Policy template reload failed, keeping cached template", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Infow("Reloaded policy template", "path", viper.GetString("policy.templatePath"), "actor", requestActor(r))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Policy template reloaded"))
+}
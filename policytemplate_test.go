@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTemplateFile points `policy.templatePath` at a temporary template
+// holding src, with an empty template cache, and returns its path.
+func useTemplateFile(t *testing.T, src string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.rego.tpl")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, "policy.templatePath", path)
+	setConfig(t, "policy.templateRoot", dir)
+	policyTemplates.Lock()
+	previous := policyTemplates.tmpl
+	policyTemplates.tmpl = nil
+	policyTemplates.Unlock()
+	t.Cleanup(func() {
+		policyTemplates.Lock()
+		policyTemplates.tmpl = previous
+		policyTemplates.Unlock()
+	})
+	return path
+}
+
+// labelledTemplate renders a minimal policy carrying label.
+func labelledTemplate(label string) string {
+	return "package api.access\n\nimport rego.v1\n\n# " + label + "\ndefault allow := false\n\nallow if input.app == \"{{ .ApplicationName }}\"\n"
+}
+
+func reloadTemplate() *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	reloadTemplateHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/reload-template", nil), sugar)
+	return rec
+}
+
+func TestReloadTemplateUpdatesCache(t *testing.T) {
+	path := useTemplateFile(t, labelledTemplate("first"))
+	if body := generateDryRun(samplePolicyData).Body.String(); !strings.Contains(body, "# first") {
+		t.Fatalf("rendered %q, want the first template", body)
+	}
+
+	if err := os.WriteFile(path, []byte(labelledTemplate("second")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Until reloaded, the cached template keeps being used.
+	if body := generateDryRun(samplePolicyData).Body.String(); !strings.Contains(body, "# first") {
+		t.Errorf("rendered %q before reload, want the cached template", body)
+	}
+	if rec := reloadTemplate(); rec.Code != http.StatusOK {
+		t.Fatalf("reload status = %d: %s", rec.Code, rec.Body)
+	}
+	if body := generateDryRun(samplePolicyData).Body.String(); !strings.Contains(body, "# second") {
+		t.Errorf("rendered %q after reload, want the new template", body)
+	}
+}
+
+func TestFailedTemplateReloadKeepsCache(t *testing.T) {
+	path := useTemplateFile(t, labelledTemplate("first"))
+	generateDryRun(samplePolicyData)
+
+	if err := os.WriteFile(path, []byte("{{ .ApplicationName "), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rec := reloadTemplate(); rec.Code != http.StatusInternalServerError {
+		t.Errorf("reload of a broken template = %d, want 500", rec.Code)
+	}
+	if body := generateDryRun(samplePolicyData).Body.String(); !strings.Contains(body, "# first") {
+		t.Errorf("rendered %q, want the cached template", body)
+	}
+}
+
+func TestTemplatePathDirectoryError(t *testing.T) {
+	dir := filepath.Dir(useTemplateFile(t, labelledTemplate("first")))
+	setConfig(t, "policy.templatePath", dir)
+
+	want := "is a directory, set it to the template file itself"
+	if _, err := cachedPolicyTemplate(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("cachedPolicyTemplate() = %v, want the directory error", err)
+	}
+	rec := generateDryRun(samplePolicyData)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("generate = %d %q, want 500 with the directory error", rec.Code, rec.Body)
+	}
+}
+
+func TestMalformedTemplateIsNotUploaded(t *testing.T) {
+	fake := useFakeS3(t)
+	for name, src := range map[string]string{
+		"syntax error":    "package api.access\n\nimport rego.v1\n\nallow if {{ .ApplicationName }} ==\n",
+		"undefined allow": "package api.access\n\nimport rego.v1\n\nlabel := \"{{ .ApplicationName }}\"\n",
+	} {
+		useTemplateFile(t, src)
+		rec := httptest.NewRecorder()
+		generatePolicyHandler(rec, httptest.NewRequest(http.MethodPost, "/generate-policy", strings.NewReader(samplePolicyData)), sugar)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"errors"`) {
+			t.Errorf("%s: generate = %d %s, want 400 with compile errors", name, rec.Code, rec.Body)
+		}
+	}
+	if _, ok := fake.object(billingPolicyKey); ok {
+		t.Error("a policy that does not compile was uploaded")
+	}
+}
+
+// actionsTemplate embeds the allowed actions with the given expression.
+func actionsTemplate(actions string) string {
+	return "package api.access\n\nimport rego.v1\n\ndefault allow := false\n\nallowed := json.unmarshal(" + actions + ")\n\nallow if input.action in allowed\n"
+}
+
+func TestTemplatesCallJSONMarshal(t *testing.T) {
+	useTemplateFile(t, actionsTemplate("{{ jsonMarshal .AllowedActions }}"))
+	rec := generateDryRun(`{"ApplicationName": "billing", "Environment": "prod", "ClientID": "client-1", "ApiName": "invoices", "ApiVersion": "v1", "AllowedActions": ["read", "say \"hi\""]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("generate status = %d: %s", rec.Code, rec.Body)
+	}
+	loadTestPolicy(t, stringLoader(rec.Body.String()))
+	for body, want := range map[string]int{
+		`{"action": "read"}`:       http.StatusOK,
+		`{"action": "say \"hi\""}`: http.StatusOK,
+		`{"action": "write"}`:      http.StatusForbidden,
+	} {
+		if rec := postEvaluate("/evaluate", body); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestPreMarshalledFieldsStillRender(t *testing.T) {
+	useTemplateFile(t, actionsTemplate("{{ jsonMarshal .AllowedActions }}"))
+	direct := generateDryRun(samplePolicyData).Body.String()
+	useTemplateFile(t, actionsTemplate("{{ .AllowedActionsJSON }}"))
+	if legacy := generateDryRun(samplePolicyData).Body.String(); legacy != direct {
+		t.Errorf("AllowedActionsJSON rendered\n%s\nwant the jsonMarshal output\n%s", legacy, direct)
+	}
+}
+
+func TestTemplateExecutionErrorKeepsServing(t *testing.T) {
+	// Indexing past the client's actions fails while the template executes.
+	useTemplateFile(t, actionsTemplate(`"[\"{{ index .AllowedActions 3 }}\"]"`))
+	rec := generateDryRun(samplePolicyData)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "failed to execute policy template") {
+		t.Errorf("generate = %d %q, want 400 with the execution error", rec.Code, rec.Body)
+	}
+
+	// The process is still up and serving later requests.
+	rec = generateDryRun(`{"ApplicationName": "billing", "Environment": "prod", "ClientID": "client-1", "ApiName": "invoices", "ApiVersion": "v1", "AllowedActions": ["a", "b", "c", "d"]}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("next generate status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestJSONMarshalQuotesForRego(t *testing.T) {
+	got, err := jsonMarshal([]string{"read", `say "hi"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"[\"read\",\"say \\\"hi\\\"\"]"`; got != want {
+		t.Errorf("jsonMarshal() = %s, want %s", got, want)
+	}
+	if _, err := jsonMarshal(func() {}); err == nil {
+		t.Error("unmarshallable value reported no error")
+	}
+}
+
+func TestCheckTemplatePath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.Mkdir(filepath.Join(root, "teams"), 0o755)
+	os.WriteFile(filepath.Join(outside, "policy.rego.tpl"), []byte(labelledTemplate("outside")), 0o644)
+	os.Symlink(filepath.Join(outside, "policy.rego.tpl"), filepath.Join(root, "escape.tpl"))
+
+	for path, ok := range map[string]bool{
+		filepath.Join(root, "policy.rego.tpl"):                    true,
+		filepath.Join(root, "teams", "policy.rego.tpl"):           true,
+		filepath.Join(root, "..", "policy.rego.tpl"):              false,
+		filepath.Join(root, "teams", "..", "..", "etc", "passwd"): false,
+		filepath.Join(outside, "policy.rego.tpl"):                 false,
+		filepath.Join(root, "escape.tpl"):                         false,
+	} {
+		if err := checkTemplatePath(path, root); (err == nil) != ok {
+			t.Errorf("checkTemplatePath(%q) = %v, want ok=%v", path, err, ok)
+		}
+	}
+	if err := checkTemplatePath("/etc/passwd", ""); err != nil {
+		t.Errorf("an empty root rejected a path: %v", err)
+	}
+}
+
+func TestTemplateOutsideRootIsNotRead(t *testing.T) {
+	useTemplateFile(t, labelledTemplate("first"))
+	setConfig(t, "policy.templatePath", "template/../../etc/passwd")
+	setConfig(t, "policy.templateRoot", "template")
+	rec := generateDryRun(samplePolicyData)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "outside policy.templateRoot") {
+		t.Errorf("generate = %d %q, want 500 with the template root error", rec.Code, rec.Body)
+	}
+}
+
+func TestCancelledRequestAbortsUpload(t *testing.T) {
+	fake := useFakeS3(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/generate-policy", strings.NewReader(samplePolicyData)).WithContext(ctx)
+	generatePolicyHandler(rec, req, sugar)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500 for an upload cancelled with the request", rec.Code)
+	}
+	if _, ok := fake.object(billingPolicyKey); ok {
+		t.Error("policy uploaded after the request was cancelled")
+	}
+}
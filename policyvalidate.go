@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// validatePolicyHandler runs the checks a generate request would, from
+// required fields and object key safety to rendering and compiling the
+// template, without uploading anything. It always answers 200 for a
+// well-formed body with {"valid": bool} and, when invalid, the "errors" of
+// the first failing stage: field and render messages as strings, compile
+// errors with their location.
+func validatePolicyHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var policyData PolicyData
+	if err := json.NewDecoder(r.Body).Decode(&policyData); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	if errs := policyData.Validate(); errs != nil {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"valid": false, "errors": errs})
+		return
+	}
+
+	tmpl, err := cachedPolicyTemplate()
+	if err != nil {
+		logInternalError(logger, "Failed to load policy template", err)
+		http.Error(w, "Failed to load policy template", http.StatusInternalServerError)
+		return
+	}
+	rendered, err := renderPolicy(tmpl, policyData)
+	if err != nil {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"valid": false, "errors": []string{err.Error()}})
+		return
+	}
+	if err := checkGeneratedPolicy(policyObjectKey(policyData), rendered.String()); err != nil {
+		writeJSON(w, r, http.StatusOK, map[string]interface{}{"valid": false, "errors": compileErrors(err)})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"valid": true})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type validationResult struct {
+	Valid  bool              `json:"valid"`
+	Errors []json.RawMessage `json:"errors"`
+}
+
+func validatePolicy(t *testing.T, body string) validationResult {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	validatePolicyHandler(rec, httptest.NewRequest(http.MethodPost, "/generate-policy/validate", strings.NewReader(body)), sugar)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("validate status = %d: %s", rec.Code, rec.Body)
+	}
+	var result validationResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("invalid validation response: %v", err)
+	}
+	return result
+}
+
+func TestValidatePolicyDataWithoutUploading(t *testing.T) {
+	fake := useFakeS3(t)
+
+	if result := validatePolicy(t, samplePolicyData); !result.Valid || len(result.Errors) != 0 {
+		t.Errorf("valid payload = %+v", result)
+	}
+	result := validatePolicy(t, `{"ApplicationName": "billing", "ApiName": "../invoices", "ApiVersion": "v1", "Environment": "prod"}`)
+	if result.Valid || len(result.Errors) != 2 {
+		t.Fatalf("bad payload = %+v, want ClientID and ApiName errors", result)
+	}
+	for i, field := range []string{"ClientID", "ApiName"} {
+		if !strings.Contains(string(result.Errors[i]), field) {
+			t.Errorf("error %d = %s, want one about %s", i, result.Errors[i], field)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.objects) != 0 {
+		t.Error("validation uploaded a policy")
+	}
+}
+
+func TestValidateReportsCompileErrors(t *testing.T) {
+	useTemplateFile(t, "package api.access\n\nimport rego.v1\n\nallow if {{ .ApplicationName }} ==\n")
+	result := validatePolicy(t, samplePolicyData)
+	if result.Valid || len(result.Errors) == 0 || !strings.Contains(string(result.Errors[0]), `"row"`) {
+		t.Errorf("broken template = %+v, want located compile errors", result)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/viper"
+)
+
+// loadedETag is the ETag of the policy object last downloaded from S3.
+var loadedETag struct {
+	sync.Mutex
+	etag string
+}
+
+func setLoadedETag(etag string) {
+	loadedETag.Lock()
+	loadedETag.etag = etag
+	loadedETag.Unlock()
+}
+
+func getLoadedETag() string {
+	loadedETag.Lock()
+	defer loadedETag.Unlock()
+	return loadedETag.etag
+}
+
+// pollS3Policy checks the policy object's ETag every interval and reloads
+// through loader only when it differs from the last downloaded one. As with
+// /reload, a caching loader is invalidated first so the reload fetches the
+// object itself and the cache then holds the copy that was installed. It
+// returns when ctx is cancelled.
+func pollS3Policy(ctx context.Context, loader PolicyLoader, interval time.Duration) {
+	s3Client := sharedS3Client
+	bucketName := viper.GetString("s3.bucketName")
+	objectKey := viper.GetString("s3.policyObjectKey")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// With a pinned version the ETag never changes, so pinning also
+		// stops polling from picking up newer versions.
+		headInput := &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(objectKey),
+		}
+		if versionID := viper.GetString("s3.policyVersionId"); versionID != "" {
+			headInput.VersionId = aws.String(versionID)
+		}
+		head, err := s3Client.HeadObject(ctx, headInput)
+		if err != nil {
+			if ctx.Err() == nil {
+				sugar.Warnw("Failed to check policy object for changes", "objectKey", objectKey, "error", err)
+			}
+			continue
+		}
+
+		etag := aws.ToString(head.ETag)
+		if etag == getLoadedETag() {
+			sugar.Debugw("Policy object unchanged", "objectKey", objectKey, "etag", etag)
+			continue
+		}
+		if cached, ok := loader.(*cachingLoader); ok {
+			cached.invalidate()
+		}
+		if err := loadAndPreparePolicy(ctx, loader); err != nil {
+			sugar.Errorw("Reload after policy object change failed", "objectKey", objectKey, "etag", etag, "error", err)
+			continue
+		}
+		sugar.Infow("Reloaded changed policy object", "objectKey", objectKey, "etag", etag)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestPollReloadRefreshesPolicyCache(t *testing.T) {
+	fake := useFakeS3(t)
+	key := viper.GetString("s3.policyObjectKey")
+	fake.put(key, accessPolicy)
+	cache := newCachingLoader(s3PolicyLoader{}, time.Hour)
+	loadTestPolicy(t, cache)
+
+	adminsOnly := strings.Replace(accessPolicy, `input.role == "reader"`, `input.role == "nobody"`, 1)
+	fake.put(key, adminsOnly)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pollS3Policy(ctx, cache, 10*time.Millisecond)
+	waitFor(t, "the changed object to be installed", func() bool {
+		return postEvaluate("/evaluate", `{"role": "reader", "action": "read"}`).Code == http.StatusForbidden
+	})
+	cancel()
+
+	// A later load through the cache must not bring the old copy back.
+	if got, err := cache.Load(context.Background()); err != nil || got != adminsOnly {
+		t.Errorf("cache.Load() after poll reload = %q, %v, want the polled policy", got, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const protobufContentType = "application/x-protobuf"
+
+// wantsProtobuf reports whether the client asked for a protobuf-encoded
+// decision through its Accept header.
+func wantsProtobuf(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == protobufContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalDecision encodes a decision as the Decision message defined in
+// proto/decision.proto. The message is small enough that it is written
+// field by field rather than through generated code.
+func marshalDecision(allow bool, decisionID, revision string) []byte {
+	var b []byte
+	if allow {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(allow))
+	}
+	if decisionID != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, decisionID)
+	}
+	if revision != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, revision)
+	}
+	return b
+}
+
+// writeProtobufDecision writes the decision as a protobuf response.
+func writeProtobufDecision(w http.ResponseWriter, status int, allow bool, decisionID, revision string) {
+	w.Header().Set("Content-Type", protobufContentType)
+	w.WriteHeader(status)
+	w.Write(marshalDecision(allow, decisionID, revision))
+}
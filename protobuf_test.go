@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodedDecision holds the fields of a proto/decision.proto Decision.
+type decodedDecision struct {
+	allow                bool
+	decisionID, revision string
+}
+
+func decodeDecision(t *testing.T, b []byte) decodedDecision {
+	t.Helper()
+	var d decodedDecision
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("invalid allow: %v", protowire.ParseError(n))
+			}
+			d.allow, b = protowire.DecodeBool(v), b[n:]
+		case (num == 2 || num == 3) && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				t.Fatalf("invalid string field: %v", protowire.ParseError(n))
+			}
+			if num == 2 {
+				d.decisionID = v
+			} else {
+				d.revision = v
+			}
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected field %d of type %d", num, typ)
+		}
+	}
+	return d
+}
+
+func evaluateProtobuf(body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(body))
+	req.Header.Set("Accept", "application/json;q=0.5, application/x-protobuf")
+	return evaluateRequest(req)
+}
+
+func TestEvaluateProtobufDecision(t *testing.T) {
+	policy := loadTestPolicy(t, stringLoader(accessPolicy))
+
+	for body, want := range map[string]struct {
+		status int
+		allow  bool
+	}{
+		`{"role": "admin"}`: {http.StatusOK, true},
+		`{"role": "guest"}`: {http.StatusForbidden, false},
+	} {
+		rec := evaluateProtobuf(body)
+		if rec.Code != want.status || rec.Header().Get("Content-Type") != protobufContentType {
+			t.Errorf("%s: got %d %s, want %d protobuf", body, rec.Code, rec.Header().Get("Content-Type"), want.status)
+			continue
+		}
+		d := decodeDecision(t, rec.Body.Bytes())
+		if d.allow != want.allow || d.decisionID != rec.Header().Get("X-Decision-ID") || d.revision != policy.revision {
+			t.Errorf("%s decoded to %+v, want allow=%v with the decision id and revision", body, d, want.allow)
+		}
+	}
+}
+
+func TestNonBooleanResultsStayJSON(t *testing.T) {
+	setConfig(t, "evaluate.allowedQueries", []string{"data.api.access.label"})
+	loadTestPolicy(t, stringLoader(accessPolicy+"\nlabel := \"admin\" if input.role == \"admin\"\n"))
+
+	req := httptest.NewRequest(http.MethodPost, "/evaluate?query=data.api.access.label", strings.NewReader(`{"role": "admin"}`))
+	req.Header.Set("Accept", protobufContentType)
+	rec := evaluateRequest(req)
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type for a string result = %q, want application/json", got)
+	}
+}
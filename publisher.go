@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/viper"
+)
+
+// decisionEvent is the record published for every evaluation.
+type decisionEvent struct {
+	DecisionID string                 `json:"decisionId"`
+	Timestamp  time.Time              `json:"timestamp"`
+	Revision   string                 `json:"revision"`
+	Allow      bool                   `json:"allow"`
+	Input      map[string]interface{} `json:"input"`
+}
+
+// messagePublisher is satisfied by *nats.Conn and by test doubles.
+type messagePublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// decisionStream batches decision events and publishes each batch as one
+// JSON array message. Events are buffered in memory; when the buffer is full
+// or the broker rejects a batch, events are dropped and counted rather than
+// slowing down evaluation.
+type decisionStream struct {
+	publisher     messagePublisher
+	subject       string
+	batchSize     int
+	flushInterval time.Duration
+	events        chan decisionEvent
+	stopped       chan struct{}
+
+	// conn and connClosed are set when the stream publishes to NATS, so
+	// shutdown can drain the connection.
+	conn       *nats.Conn
+	connClosed chan struct{}
+}
+
+// decisions is nil unless `decisions.nats.url` is configured.
+var decisions *decisionStream
+
+// startDecisionStream connects to NATS when configured and starts the
+// batching goroutine, which flushes what is buffered once ctx is done.
+func startDecisionStream(ctx context.Context) error {
+	url := viper.GetString("decisions.nats.url")
+	if url == "" {
+		return nil
+	}
+
+	// Keep retrying in the background so a broker outage at startup or
+	// later never blocks the service.
+	connClosed := make(chan struct{})
+	conn, err := nats.Connect(url, nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1),
+		nats.ClosedHandler(func(*nats.Conn) { close(connClosed) }))
+	if err != nil {
+		return err
+	}
+
+	decisions = newDecisionStream(conn, viper.GetString("decisions.nats.subject"),
+		viper.GetInt("decisions.batchSize"), viper.GetDuration("decisions.flushInterval"), viper.GetInt("decisions.bufferSize"))
+	decisions.conn, decisions.connClosed = conn, connClosed
+	go decisions.run(ctx)
+	return nil
+}
+
+func newDecisionStream(publisher messagePublisher, subject string, batchSize int, flushInterval time.Duration, bufferSize int) *decisionStream {
+	return &decisionStream{
+		publisher:     publisher,
+		subject:       subject,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		events:        make(chan decisionEvent, bufferSize),
+		stopped:       make(chan struct{}),
+	}
+}
+
+// publish queues event without blocking.
+func (s *decisionStream) publish(event decisionEvent) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		decisionsDroppedTotal.Inc()
+	}
+}
+
+func (s *decisionStream) run(ctx context.Context) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]decisionEvent, 0, s.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			s.flushPending(batch)
+			return
+		case event := <-s.events:
+			batch = append(batch, event)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		s.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flushPending publishes batch along with everything still buffered.
+func (s *decisionStream) flushPending(batch []decisionEvent) {
+	for {
+		select {
+		case event := <-s.events:
+			batch = append(batch, event)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		default:
+			if len(batch) > 0 {
+				s.flush(batch)
+			}
+			return
+		}
+		s.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// close waits for run to stop, publishes any events queued by requests
+// that were still in flight, then drains the NATS connection. It gives up
+// when ctx is done.
+func (s *decisionStream) close(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return
+	}
+	s.flushPending(nil)
+	if s.conn == nil {
+		return
+	}
+	if err := s.conn.Drain(); err != nil {
+		sugar.Warnw("Failed to drain decision stream connection", "error", err)
+		return
+	}
+	select {
+	case <-s.connClosed:
+	case <-ctx.Done():
+		s.conn.Close()
+	}
+}
+
+func (s *decisionStream) flush(batch []decisionEvent) {
+	body, err := json.Marshal(batch)
+	if err == nil {
+		err = s.publisher.Publish(s.subject, body)
+	}
+	if err != nil {
+		sugar.Warnw("Failed to publish decisions", "count", len(batch), "error", err)
+		decisionsDroppedTotal.Add(float64(len(batch)))
+		return
+	}
+	decisionsPublishedTotal.Add(float64(len(batch)))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockPublisher records published messages, failing while err is set.
+type mockPublisher struct {
+	mu       sync.Mutex
+	subjects []string
+	batches  [][]decisionEvent
+	err      error
+}
+
+func (p *mockPublisher) Publish(subject string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	var batch []decisionEvent
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return err
+	}
+	p.subjects = append(p.subjects, subject)
+	p.batches = append(p.batches, batch)
+	return nil
+}
+
+func (p *mockPublisher) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// published returns the ids of every published decision, in order.
+func (p *mockPublisher) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ids []string
+	for _, batch := range p.batches {
+		for _, event := range batch {
+			ids = append(ids, event.DecisionID)
+		}
+	}
+	return ids
+}
+
+// useDecisionStream routes decision events to a running stream over
+// publisher for the test. Calling the returned func stops the stream.
+func useDecisionStream(t *testing.T, publisher messagePublisher, batchSize int, flushInterval time.Duration) context.CancelFunc {
+	t.Helper()
+	previous := decisions
+	ctx, cancel := context.WithCancel(context.Background())
+	decisions = newDecisionStream(publisher, "policy.decisions", batchSize, flushInterval, 16)
+	go decisions.run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		decisions = previous
+	})
+	return cancel
+}
+
+func TestDecisionsArePublishedInBatches(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	publisher := &mockPublisher{}
+	useDecisionStream(t, publisher, 2, 50*time.Millisecond)
+
+	var ids []string
+	for _, body := range []string{`{"role": "admin"}`, `{"role": "guest"}`, `{"role": "reader", "action": "read"}`} {
+		ids = append(ids, postEvaluate("/evaluate", body).Header().Get("X-Decision-ID"))
+	}
+
+	// Two events fill a batch; the third goes out on the flush interval.
+	waitFor(t, "all decisions to be published", func() bool { return len(publisher.published()) == 3 })
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	if len(publisher.batches) != 2 || len(publisher.batches[0]) != 2 {
+		t.Errorf("batches = %+v, want a full batch of 2 then 1", publisher.batches)
+	}
+	for i, event := range append(publisher.batches[0], publisher.batches[1]...) {
+		if event.DecisionID != ids[i] {
+			t.Errorf("event %d has id %s, want %s", i, event.DecisionID, ids[i])
+		}
+	}
+	if allow := publisher.batches[0][1].Allow; allow {
+		t.Error("the guest's decision was published as an allow")
+	}
+	for _, subject := range publisher.subjects {
+		if subject != "policy.decisions" {
+			t.Errorf("published to %q, want the configured subject", subject)
+		}
+	}
+}
+
+func TestBrokerFailuresDropDecisions(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	publisher := &mockPublisher{}
+	publisher.fail(errors.New("nats: connection closed"))
+	useDecisionStream(t, publisher, 1, time.Hour)
+	dropped := readMetric(t, decisionsDroppedTotal).GetCounter().GetValue()
+
+	postEvaluate("/evaluate", `{"role": "admin"}`)
+	waitFor(t, "the failed batch to be dropped", func() bool {
+		return readMetric(t, decisionsDroppedTotal).GetCounter().GetValue() == dropped+1
+	})
+
+	// Publishing resumes once the broker is back.
+	publisher.fail(nil)
+	postEvaluate("/evaluate", `{"role": "admin"}`)
+	waitFor(t, "the next decision to be published", func() bool { return len(publisher.published()) == 1 })
+}
+
+func TestFullBufferDropsWithoutBlocking(t *testing.T) {
+	stream := newDecisionStream(&mockPublisher{}, "policy.decisions", 10, time.Hour, 1)
+	dropped := readMetric(t, decisionsDroppedTotal).GetCounter().GetValue()
+	stream.publish(decisionEvent{DecisionID: "1"})
+	stream.publish(decisionEvent{DecisionID: "2"})
+	if got := readMetric(t, decisionsDroppedTotal).GetCounter().GetValue() - dropped; got != 1 {
+		t.Errorf("dropped %v events, want 1", got)
+	}
+}
+
+func TestShutdownFlushesBufferedDecisions(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	publisher := &mockPublisher{}
+	stop := useDecisionStream(t, publisher, 10, time.Hour)
+	stream := decisions
+
+	var ids []string
+	for _, body := range []string{`{"role": "admin"}`, `{"role": "guest"}`, `{"role": "admin"}`} {
+		ids = append(ids, postEvaluate("/evaluate", body).Header().Get("X-Decision-ID"))
+	}
+	stop()
+	// A request still in flight when background work stops.
+	ids = append(ids, postEvaluate("/evaluate", `{"role": "admin"}`).Header().Get("X-Decision-ID"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stream.close(ctx)
+	if got := publisher.published(); !reflect.DeepEqual(got, ids) {
+		t.Errorf("published %v on shutdown, want %v", got, ids)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "strings"
+
+// redactedValue replaces redacted fields in logged or echoed inputs.
+const redactedValue = "[REDACTED]"
+
+// redactInput returns a copy of input with each field in paths replaced by
+// redactedValue. Paths are dot-separated, e.g. "user.password"; paths that
+// do not exist in input are ignored. The original input is left untouched.
+func redactInput(input map[string]interface{}, paths []string) map[string]interface{} {
+	out := copyMap(input)
+	for _, path := range paths {
+		redactPath(out, strings.Split(path, "."))
+	}
+	return out
+}
+
+func redactPath(m map[string]interface{}, path []string) {
+	v, ok := m[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		m[path[0]] = redactedValue
+		return
+	}
+	if child, ok := v.(map[string]interface{}); ok {
+		child = copyMap(child)
+		m[path[0]] = child
+		redactPath(child, path[1:])
+	}
+}
+
+// copyMap returns a shallow copy of m.
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// policyLoader is the loader the startup policy came from, reused for
+// background refreshes.
+var policyLoader PolicyLoader
+
+// refresh tracks when the policy was last (re)loaded or a refresh attempted.
+var refresh struct {
+	sync.Mutex
+	lastAttempt time.Time
+	running     bool
+}
+
+// refreshIfStale starts a background reload once the loaded policy is older
+// than `policy.maxAge`. The request that notices keeps using the current
+// query; at most one refresh runs at a time, and after a failed attempt the
+// next one waits another maxAge.
+func refreshIfStale() {
+	maxAge := viper.GetDuration("policy.maxAge")
+	if maxAge <= 0 || policyLoader == nil {
+		return
+	}
+
+	refresh.Lock()
+	defer refresh.Unlock()
+	if refresh.running || time.Since(refresh.lastAttempt) < maxAge {
+		return
+	}
+	refresh.running = true
+	refresh.lastAttempt = time.Now()
+
+	go func() {
+		if err := loadAndPreparePolicy(context.Background(), policyLoader); err != nil {
+			sugar.Errorw("Background policy refresh failed", "error", err)
+		} else {
+			sugar.Infow("Refreshed stale policy", "maxAge", maxAge.String())
+		}
+
+		refresh.Lock()
+		refresh.running = false
+		refresh.Unlock()
+	}()
+}
+
+// markRefreshed records a successful load so the max-age clock restarts.
+func markRefreshed() {
+	refresh.Lock()
+	refresh.lastAttempt = time.Now()
+	refresh.Unlock()
+}
+
+// reloadHandler reloads the policy on demand, e.g. from a deploy webhook.
+// The policy is fetched from its source even when cached. The new policy
+// is swapped in only if it loads and compiles; otherwise the current one
+// keeps serving and the error is returned.
+func reloadHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cached, ok := policyLoader.(*cachingLoader); ok {
+		cached.invalidate()
+	}
+
+	if err := loadAndPreparePolicy(r.Context(), policyLoader); err != nil {
+		logger.Errorw("Policy reload failed", "error", err, "actor", requestActor(r))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	revision := policies.Get().revision
+	logger.Infow("Reloaded policy", "revision", revision, "actor", requestActor(r))
+	w.Header().Set("X-Policy-Revision", revision)
+	writeJSON(w, r, http.StatusOK, map[string]interface{}{"revision": revision})
+}
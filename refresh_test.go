@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usePolicyLoader installs loader as the background refresh source.
+func usePolicyLoader(t *testing.T, loader PolicyLoader) {
+	t.Helper()
+	previous := policyLoader
+	policyLoader = loader
+	t.Cleanup(func() { policyLoader = previous })
+}
+
+func TestStalePolicyIsRefreshed(t *testing.T) {
+	source := &switchLoader{policy: accessPolicy}
+	usePolicyLoader(t, source)
+	setConfig(t, "policy.maxAge", "50ms")
+	first := loadTestPolicy(t, source)
+
+	adminsOnly := strings.Replace(accessPolicy, `input.role == "reader"`, `input.role == "nobody"`, 1)
+	source.set(adminsOnly, nil)
+
+	// A fresh policy is served without going back to the source.
+	postEvaluate("/evaluate", `{"role": "admin"}`)
+	if got := source.count(); got != 1 {
+		t.Errorf("source loaded %d times before max-age, want 1", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if rec := postEvaluate("/evaluate", `{"role": "reader", "action": "read"}`); rec.Code != http.StatusOK {
+		t.Errorf("request noticing the stale policy got %d, want 200 from the current query", rec.Code)
+	}
+	waitFor(t, "the background refresh", func() bool { return policies.Get() != first })
+	if got := source.count(); got != 2 {
+		t.Errorf("source loaded %d times, want a single refresh", got)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "reader", "action": "read"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("status after refresh = %d, want 403 from the refreshed policy", rec.Code)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/spf13/viper"
+)
+
+// imdsRegionTimeout bounds the instance metadata lookup so startup off EC2
+// does not stall waiting for an endpoint that will never answer.
+const imdsRegionTimeout = 2 * time.Second
+
+// resolveRegion picks the AWS region from, in order, `s3.region`, the
+// AWS_REGION environment variable and the EC2 instance metadata service.
+// It also returns where the region came from; both are empty when none of
+// the sources provide one and the SDK defaults apply.
+func resolveRegion(ctx context.Context) (region, source string) {
+	if region := viper.GetString("s3.region"); region != "" {
+		return region, "config"
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region, "env"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imdsRegionTimeout)
+	defer cancel()
+	if out, err := imds.New(imds.Options{}).GetRegion(ctx, &imds.GetRegionInput{}); err == nil && out.Region != "" {
+		return out.Region, "imds"
+	}
+	return "", ""
+}
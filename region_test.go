@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useFakeIMDS serves region from a fake instance metadata endpoint.
+func useFakeIMDS(t *testing.T, region string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+			w.Write([]byte("token"))
+		case r.Method == http.MethodGet && r.URL.Path == "/latest/dynamic/instance-identity/document":
+			fmt.Fprintf(w, `{"region": %q}`, region)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", srv.URL)
+}
+
+func TestResolveRegionPrecedence(t *testing.T) {
+	useFakeIMDS(t, "ap-south-1")
+	setConfig(t, "s3.region", "eu-central-1")
+	t.Setenv("AWS_REGION", "us-west-2")
+
+	steps := []struct {
+		wantRegion, wantSource string
+		next                   func()
+	}{
+		{"eu-central-1", "config", func() { setConfig(t, "s3.region", "") }},
+		{"us-west-2", "env", func() { t.Setenv("AWS_REGION", "") }},
+		{"ap-south-1", "imds", func() { t.Setenv("AWS_EC2_METADATA_DISABLED", "true") }},
+		{"", "", nil},
+	}
+	for _, step := range steps {
+		region, source := resolveRegion(context.Background())
+		if region != step.wantRegion || source != step.wantSource {
+			t.Errorf("resolveRegion() = %q, %q, want %q, %q", region, source, step.wantRegion, step.wantSource)
+		}
+		if step.next != nil {
+			step.next()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+)
+
+// revisions keeps recently loaded policies, oldest first, so canary clients
+// can pin a revision other than the current one. Whole policies are kept so
+// a pinned request is decided, explained and annotated by one revision.
+// Lookups take a read lock, so concurrent evaluations do not serialize on
+// the cache; only reloads take the write lock.
+var revisions struct {
+	sync.RWMutex
+	entries []revisionEntry
+
+	// Lookup outcomes backing the prepared_query_cache_hit_ratio gauge.
+	hits, misses atomic.Uint64
+}
+
+type revisionEntry struct {
+	revision string
+	policy   *loadedPolicy
+}
+
+// retainRevision records a newly loaded policy, dropping the oldest
+// revisions beyond `policy.retainedRevisions`.
+func retainRevision(policy *loadedPolicy) {
+	revisions.Lock()
+	defer revisions.Unlock()
+
+	for i, e := range revisions.entries {
+		if e.revision == policy.revision {
+			revisions.entries = append(revisions.entries[:i], revisions.entries[i+1:]...)
+			break
+		}
+	}
+	revisions.entries = append(revisions.entries, revisionEntry{revision: policy.revision, policy: policy})
+
+	if max := viper.GetInt("policy.retainedRevisions"); max > 0 && len(revisions.entries) > max {
+		revisions.entries = revisions.entries[len(revisions.entries)-max:]
+	}
+}
+
+// revisionPolicy returns a retained revision, counting the lookup as a
+// cache hit or miss.
+func revisionPolicy(revision string) (*loadedPolicy, bool) {
+	revisions.RLock()
+	defer revisions.RUnlock()
+
+	for _, e := range revisions.entries {
+		if e.revision == revision {
+			countPreparedQueryLookup(true)
+			return e.policy, true
+		}
+	}
+	countPreparedQueryLookup(false)
+	return nil, false
+}
+
+// currentPolicy returns the current policy for an evaluation, counting the
+// lookup as a cache hit, or a miss while no policy is loaded.
+func currentPolicy() *loadedPolicy {
+	policy := policies.Get()
+	countPreparedQueryLookup(policy != nil)
+	return policy
+}
+
+func countPreparedQueryLookup(hit bool) {
+	if hit {
+		revisions.hits.Add(1)
+		preparedQueryCacheHitsTotal.Inc()
+		return
+	}
+	revisions.misses.Add(1)
+	preparedQueryCacheMissesTotal.Inc()
+}
+
+// revisionCacheHitRatio returns the share of prepared-query lookups, for the
+// current or a pinned revision, that found a prepared query, or 0 before the
+// first lookup.
+func revisionCacheHitRatio() float64 {
+	hits, misses := revisions.hits.Load(), revisions.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEvaluatePinnedRevision(t *testing.T) {
+	stable := accessPolicy + "\ndeny contains \"stable denies\" if not allow\n"
+	canary := strings.Replace(accessPolicy, `input.role == "reader"`, `input.role == "nobody"`, 1) +
+		"\ndeny contains \"canary denies\" if not allow\n"
+	first := loadTestPolicy(t, stringLoader(stable))
+	second := loadTestPolicy(t, stringLoader(canary))
+	if first.revision == second.revision {
+		t.Fatal("both policies share a revision")
+	}
+
+	reader := `{"role": "reader", "action": "read"}`
+	rec := postEvaluate("/evaluate", reader)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("X-Policy-Revision") != second.revision {
+		t.Errorf("current revision: got %d from %s, want 403 from %s", rec.Code, rec.Header().Get("X-Policy-Revision"), second.revision)
+	}
+	rec = postEvaluate("/evaluate?revision="+first.revision, reader)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Policy-Revision") != first.revision {
+		t.Errorf("pinned revision: got %d from %s, want 200 from %s", rec.Code, rec.Header().Get("X-Policy-Revision"), first.revision)
+	}
+
+	// Denial reasons come from the pinned revision too.
+	rec = postEvaluate("/evaluate?revision="+first.revision, `{"role": "guest"}`)
+	var body struct {
+		Reasons []string `json:"reasons"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	if want := []string{"stable denies"}; !reflect.DeepEqual(body.Reasons, want) {
+		t.Errorf("pinned reasons = %v, want %v", body.Reasons, want)
+	}
+
+	if rec := postEvaluate("/evaluate?revision=unknown", reader); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown revision status = %d, want 404", rec.Code)
+	}
+}
+
+func TestRetainRevisionDropsOldest(t *testing.T) {
+	setConfig(t, "policy.retainedRevisions", 2)
+	revisions.Lock()
+	saved := revisions.entries
+	revisions.entries = nil
+	revisions.Unlock()
+	t.Cleanup(func() {
+		revisions.Lock()
+		revisions.entries = saved
+		revisions.Unlock()
+	})
+
+	for _, revision := range []string{"a", "b", "c"} {
+		retainRevision(&loadedPolicy{revision: revision})
+	}
+	if _, ok := revisionPolicy("a"); ok {
+		t.Error("oldest revision still retained")
+	}
+	for _, revision := range []string{"b", "c"} {
+		if policy, ok := revisionPolicy(revision); !ok || policy.revision != revision {
+			t.Errorf("revision %s not retained", revision)
+		}
+	}
+}
+
+func TestRevisionCacheMetricsUnderConcurrency(t *testing.T) {
+	first := loadTestPolicy(t, stringLoader(accessPolicy))
+	loadTestPolicy(t, stringLoader(accessPolicy+"\nlabel := \"canary\"\n"))
+	hits := readMetric(t, preparedQueryCacheHitsTotal).GetCounter().GetValue()
+	misses := readMetric(t, preparedQueryCacheMissesTotal).GetCounter().GetValue()
+
+	const workers, perWorker = 8, 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				postEvaluate("/evaluate?revision="+first.revision, `{"role": "admin"}`)
+				postEvaluate("/evaluate?revision=unknown", `{"role": "admin"}`)
+				// Evaluations of the current revision use its prepared query too.
+				postEvaluate("/evaluate", `{"role": "admin"}`)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := readMetric(t, preparedQueryCacheHitsTotal).GetCounter().GetValue() - hits; got != 2*workers*perWorker {
+		t.Errorf("hits advanced by %v, want %d", got, 2*workers*perWorker)
+	}
+	if got := readMetric(t, preparedQueryCacheMissesTotal).GetCounter().GetValue() - misses; got != workers*perWorker {
+		t.Errorf("misses advanced by %v, want %d", got, workers*perWorker)
+	}
+	if ratio := readMetric(t, preparedQueryCacheHitRatio).GetGauge().GetValue(); ratio <= 0 || ratio >= 1 {
+		t.Errorf("hit ratio = %v, want a share between hits and misses", ratio)
+	}
+}
+
+func TestPreparedQueryLookupsCountCurrentPolicy(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	hits := readMetric(t, preparedQueryCacheHitsTotal).GetCounter().GetValue()
+	misses := readMetric(t, preparedQueryCacheMissesTotal).GetCounter().GetValue()
+
+	postEvaluate("/evaluate", `{"role": "admin"}`)
+	postEvaluate("/evaluate?revision="+policies.Get().revision, `{"role": "admin"}`)
+	postBatch("/evaluate/batch", `[{"role": "admin"}, {"role": "guest"}]`)
+	policies.Set(nil)
+	postEvaluate("/evaluate", `{"role": "admin"}`)
+
+	if got := readMetric(t, preparedQueryCacheHitsTotal).GetCounter().GetValue() - hits; got != 3 {
+		t.Errorf("hits advanced by %v, want one per request served by the current policy", got)
+	}
+	if got := readMetric(t, preparedQueryCacheMissesTotal).GetCounter().GetValue() - misses; got != 1 {
+		t.Errorf("misses advanced by %v, want 1 while no policy is loaded", got)
+	}
+}
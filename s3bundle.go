@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/open-policy-agent/opa/util"
+	"github.com/spf13/viper"
+)
+
+// s3PrefixLoader loads every object under `s3.policyPrefix`: each .rego
+// object becomes a module named by its key below the prefix, and each
+// data.json becomes the data document at its directory, following the OPA
+// bundle layout (a/b/data.json is data.a.b). The PolicyData sidecars of
+// generated policies and any other objects are ignored.
+type s3PrefixLoader struct {
+	prefix string
+}
+
+func (l s3PrefixLoader) LoadBundle(ctx context.Context) (policySet, error) {
+	s3Client := sharedS3Client
+	bucketName := viper.GetString("s3.bucketName")
+	set := policySet{modules: map[string]string{}, data: map[string]interface{}{}}
+
+	// The listing already tells how many modules and how much data the
+	// bundle holds, so oversized bundles are refused before any download.
+	var modules, documents []string
+	var dataSize int64
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(l.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return set, fmt.Errorf("failed to list policies under %s: %w", l.prefix, err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			name := l.objectName(key)
+			switch {
+			case strings.HasSuffix(name, ".rego"):
+				modules = append(modules, key)
+			case path.Base(name) == "data.json":
+				documents = append(documents, key)
+				dataSize += aws.ToInt64(obj.Size)
+			}
+		}
+		if err := checkBundleSize(len(modules), dataSize); err != nil {
+			return set, err
+		}
+	}
+	if len(modules) == 0 {
+		return set, fmt.Errorf("no .rego objects found under %s", l.prefix)
+	}
+
+	for _, key := range modules {
+		content, err := fetchS3Object(ctx, s3Client, bucketName, key)
+		if err != nil {
+			return set, err
+		}
+		set.modules[l.objectName(key)] = string(content)
+	}
+	for _, key := range documents {
+		content, err := fetchS3Object(ctx, s3Client, bucketName, key)
+		if err != nil {
+			return set, err
+		}
+		var doc interface{}
+		if err := util.Unmarshal(content, &doc); err != nil {
+			return set, fmt.Errorf("failed to decode %s: %w", key, err)
+		}
+		if err := mergeDataDocument(set.data, path.Dir(l.objectName(key)), doc); err != nil {
+			return set, fmt.Errorf("failed to load %s: %w", key, err)
+		}
+		set.dataSize += len(content)
+	}
+	return set, nil
+}
+
+// objectName returns key relative to the prefix, which names modules and
+// places data documents.
+func (l s3PrefixLoader) objectName(key string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(key, l.prefix), "/")
+}
+
+func (l s3PrefixLoader) LoadModules(ctx context.Context) (map[string]string, error) {
+	set, err := l.LoadBundle(ctx)
+	return set.modules, err
+}
+
+func (l s3PrefixLoader) Load(ctx context.Context) (string, error) {
+	return "", fmt.Errorf("policies under %s are loaded as a bundle, use LoadBundle", l.prefix)
+}
+
+// fetchS3Object downloads one object, holding an S3 fetch slot while doing so.
+func fetchS3Object(ctx context.Context, s3Client *s3.Client, bucketName, key string) ([]byte, error) {
+	if err := acquireS3Fetch(ctx); err != nil {
+		return nil, fmt.Errorf("waiting for an S3 fetch slot: %w", err)
+	}
+	defer releaseS3Fetch()
+
+	resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s from S3: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// mergeDataDocument places doc at dir ("." for the root) within data. Root
+// documents must be objects; their keys are merged into data. Objects from
+// different documents are merged key by key, and any other value written
+// twice is an error, so the result never depends on listing order.
+func mergeDataDocument(data map[string]interface{}, dir string, doc interface{}) error {
+	if dir != "." {
+		segments := strings.Split(dir, "/")
+		for i := len(segments) - 1; i >= 0; i-- {
+			doc = map[string]interface{}{segments[i]: doc}
+		}
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("root data document must be an object")
+	}
+	return mergeDataObjects(data, obj, "")
+}
+
+// mergeDataObjects merges src into dst, reporting a path where both define
+// a value that is not an object.
+func mergeDataObjects(dst, src map[string]interface{}, at string) error {
+	for k, v := range src {
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		existingObj, existingOK := existing.(map[string]interface{})
+		obj, objOK := v.(map[string]interface{})
+		if !existingOK || !objOK {
+			return fmt.Errorf("data path %s is defined by more than one document", path.Join(at, k))
+		}
+		if err := mergeDataObjects(existingObj, obj, path.Join(at, k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
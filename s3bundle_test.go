@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestS3PrefixBundleWithImports(t *testing.T) {
+	fake := useFakeS3(t)
+	fake.put("bundle/api/access.rego", `package api.access
+
+import rego.v1
+
+import data.api.roles
+
+default allow := false
+
+allow if roles.is_admin
+`)
+	fake.put("bundle/api/roles.rego", `package api.roles
+
+import rego.v1
+
+is_admin if data.directory.roles[input.user] == "admin"
+`)
+	fake.put("bundle/directory/data.json", `{"roles": {"alice": "admin", "bob": "reader"}}`)
+	// The PolicyData sidecar of a generated policy is not a module.
+	fake.put("bundle/api/access.json", samplePolicyData)
+
+	setConfig(t, "policy.loader", "s3")
+	setConfig(t, "s3.policyPrefix", "bundle/")
+	loader, err := newPolicyLoader()
+	if err != nil {
+		t.Fatalf("newPolicyLoader() = %v", err)
+	}
+	policy := loadTestPolicy(t, loader)
+	if len(policy.modules) != 2 || policy.modules["api/roles.rego"] == "" {
+		t.Errorf("modules = %v, want api/access.rego and api/roles.rego", policy.modules)
+	}
+
+	for body, want := range map[string]int{
+		`{"user": "alice"}`: http.StatusOK,
+		`{"user": "bob"}`:   http.StatusForbidden,
+	} {
+		if rec := postEvaluate("/evaluate", body); rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, want)
+		}
+	}
+}
+
+func TestMergeDataDocumentsIndependentOfOrder(t *testing.T) {
+	type document struct {
+		dir string
+		doc interface{}
+	}
+	// Merging takes ownership of the documents, so each order gets fresh ones.
+	nested := func() []document {
+		return []document{
+			{"directory", map[string]interface{}{"teams": map[string]interface{}{"billing": "alice"}}},
+			{"directory/teams", map[string]interface{}{"payroll": "bob"}},
+			{".", map[string]interface{}{"flags": true}},
+		}
+	}
+	want := map[string]interface{}{
+		"directory": map[string]interface{}{"teams": map[string]interface{}{"billing": "alice", "payroll": "bob"}},
+		"flags":     true,
+	}
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}} {
+		data, docs := map[string]interface{}{}, nested()
+		for _, i := range order {
+			if err := mergeDataDocument(data, docs[i].dir, docs[i].doc); err != nil {
+				t.Fatalf("order %v: mergeDataDocument(%s) = %v", order, docs[i].dir, err)
+			}
+		}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("order %v: data = %v, want %v", order, data, want)
+		}
+	}
+
+	// The same path written twice is a conflict whichever comes first.
+	conflicting := []document{
+		{"directory", map[string]interface{}{"teams": map[string]interface{}{"billing": "alice"}}},
+		{"directory/teams/billing", "carol"},
+	}
+	for _, order := range [][]int{{0, 1}, {1, 0}} {
+		data := map[string]interface{}{}
+		err := mergeDataDocument(data, conflicting[order[0]].dir, conflicting[order[0]].doc)
+		if err == nil {
+			err = mergeDataDocument(data, conflicting[order[1]].dir, conflicting[order[1]].doc)
+		}
+		if err == nil || !strings.Contains(err.Error(), "directory/teams/billing") {
+			t.Errorf("order %v: err = %v, want a conflict at directory/teams/billing", order, err)
+		}
+	}
+}
+
+func TestS3PrefixBundleRejectsConflictingData(t *testing.T) {
+	fake := useFakeS3(t)
+	fake.put("bundle/api/access.rego", accessPolicy)
+	fake.put("bundle/data.json", `{"directory": {"roles": {"alice": "admin"}}}`)
+	fake.put("bundle/directory/data.json", `{"roles": {"alice": "reader"}}`)
+
+	_, err := (s3PrefixLoader{prefix: "bundle/"}).LoadBundle(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "directory/roles/alice") {
+		t.Errorf("LoadBundle() = %v, want a conflict at directory/roles/alice", err)
+	}
+}
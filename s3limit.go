@@ -0,0 +1,36 @@
+package main
+
+import "context"
+
+// s3FetchSlots bounds concurrent policy downloads from S3 so simultaneous
+// reloads cannot overwhelm it. Nil means unlimited.
+var s3FetchSlots chan struct{}
+
+// initS3FetchLimit sizes the fetch semaphore; n <= 0 disables the limit.
+func initS3FetchLimit(n int) {
+	if n <= 0 {
+		s3FetchSlots = nil
+		return
+	}
+	s3FetchSlots = make(chan struct{}, n)
+}
+
+// acquireS3Fetch waits for a fetch slot or for ctx to end.
+func acquireS3Fetch(ctx context.Context) error {
+	if s3FetchSlots == nil {
+		return nil
+	}
+	select {
+	case s3FetchSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseS3Fetch frees a slot taken by acquireS3Fetch.
+func releaseS3Fetch() {
+	if s3FetchSlots != nil {
+		<-s3FetchSlots
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// useS3FetchLimit sizes the fetch semaphore for the test.
+func useS3FetchLimit(t *testing.T, n int) {
+	t.Helper()
+	previous := s3FetchSlots
+	initS3FetchLimit(n)
+	t.Cleanup(func() { s3FetchSlots = previous })
+}
+
+func TestMassReloadRespectsFetchLimit(t *testing.T) {
+	fake := useFakeS3(t)
+	fake.delay = 20 * time.Millisecond
+	useS3FetchLimit(t, 2)
+	bucket := viper.GetString("s3.bucketName")
+	for i := 0; i < 8; i++ {
+		fake.put(fmt.Sprintf("apps/app%d.rego", i), fmt.Sprintf("package app%d\n", i))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := fetchS3Object(context.Background(), sharedS3Client, bucket, fmt.Sprintf("apps/app%d.rego", i))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("fetch failed: %v", err)
+		}
+	}
+
+	if got := fake.concurrency(); got != 2 {
+		t.Errorf("at most %d fetches ran at once, want exactly the limit of 2", got)
+	}
+}
+
+func TestFetchLimitHonorsContext(t *testing.T) {
+	useS3FetchLimit(t, 1)
+	if err := acquireS3Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer releaseS3Fetch()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := acquireS3Fetch(ctx); err == nil {
+		t.Error("acquired a slot beyond the limit")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// inputSchema validates /evaluate inputs when `evaluate.inputSchema` is set.
+var inputSchema *gojsonschema.Schema
+
+// loadInputSchema compiles the configured JSON Schema once at startup.
+func loadInputSchema() error {
+	path := viper.GetString("evaluate.inputSchema")
+	if path == "" {
+		return nil
+	}
+
+	schemaBytes, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read input schema: %w", err)
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schemaBytes))
+	if err != nil {
+		return fmt.Errorf("failed to compile input schema %s: %w", path, err)
+	}
+
+	inputSchema = schema
+	return nil
+}
+
+// validateInput returns the schema violations for input, or nil when the
+// input is valid or no schema is configured.
+func validateInput(input interface{}) ([]string, error) {
+	if inputSchema == nil {
+		return nil, nil
+	}
+
+	result, err := inputSchema.Validate(gojsonschema.NewGoLoader(input))
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []string
+	for _, e := range result.Errors() {
+		violations = append(violations, e.String())
+	}
+	return violations, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useInputSchema loads schema as `evaluate.inputSchema` for the test.
+func useInputSchema(t *testing.T, schema string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "input.schema.json")
+	if err := os.WriteFile(path, []byte(schema), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	previous := inputSchema
+	t.Cleanup(func() { inputSchema = previous })
+	setConfig(t, "evaluate.inputSchema", path)
+	if err := loadInputSchema(); err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+}
+
+const roleSchema = `{
+	"type": "object",
+	"required": ["role"],
+	"properties": {
+		"role": {"type": "string", "enum": ["admin", "reader", "guest"]}
+	}
+}`
+
+func TestEvaluateRejectsInputFailingSchema(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	useInputSchema(t, roleSchema)
+
+	rec := postEvaluate("/evaluate", `{"role": 7}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, rec.Body)
+	}
+	if len(body.Errors) == 0 {
+		t.Error("no schema errors reported")
+	}
+
+	if rec := postEvaluate("/evaluate", `{"role": "admin"}`); rec.Code != http.StatusOK {
+		t.Errorf("valid input status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestLoadInputSchemaRejectsInvalidSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.json")
+	if err := os.WriteFile(path, []byte(`{"type": 12}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	previous := inputSchema
+	t.Cleanup(func() { inputSchema = previous })
+	setConfig(t, "evaluate.inputSchema", path)
+	if err := loadInputSchema(); err == nil {
+		t.Error("invalid schema accepted")
+	}
+}
@@ -3,28 +3,44 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var (
 	// Global logger
 	sugar *zap.SugaredLogger
-	// Global OPA query, prepared at startup
-	regoQuery *rego.PreparedEvalQuery
+	// Bounds concurrent evaluations; nil means unlimited
+	evalSlots *evalLimiter
 )
 
 // PolicyData reflects the dynamic parts of your policy.
@@ -43,78 +59,680 @@ func initConfig() {
 	viper.SetConfigName("config") // name of the config file (without extension)
 	viper.SetConfigType("yaml")   // extension of the config file
 	viper.AutomaticEnv()          // Automatically override values from environment variables
+	// Nested keys map to upper-cased env vars with dots replaced by
+	// underscores, e.g. s3.bucketName is overridden by S3_BUCKETNAME.
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
-	if err := viper.ReadInConfig(); err != nil {
+	viper.SetDefault("evaluate.maxDepth", 32)
+	viper.SetDefault("evaluate.maxKeys", 1000)
+	viper.SetDefault("server.address", ":8080")
+	viper.SetDefault("server.trailingSlash", "strip")
+	viper.SetDefault("server.readyGracePeriod", "0s")
+	viper.SetDefault("server.shutdownDelay", "10s")
+	viper.SetDefault("server.shutdownTimeout", "30s")
+	viper.SetDefault("evaluate.maxDeadline", "5s")
+	viper.SetDefault("evaluate.maxCacheTTL", 300)
+	viper.SetDefault("evaluate.edgeCacheTTL", 60)
+	viper.SetDefault("evaluate.maxBatch", 100)
+	viper.SetDefault("evaluate.maxConcurrent", 0)
+	viper.SetDefault("evaluate.maxQueue", 100)
+	viper.SetDefault("evaluate.retryAfter", 1)
+	viper.SetDefault("evaluate.responseContentType", "application/json")
+	viper.SetDefault("evaluate.reasonQuery", "data.api.access.reason")
+	viper.SetDefault("evaluate.denyQuery", "data.api.access.deny")
+	viper.SetDefault("evaluate.notApplicableStatus", 200)
+	viper.SetDefault("evaluate.roleField", "role")
+	viper.SetDefault("decisions.nats.subject", "opa.decisions")
+	viper.SetDefault("decisions.batchSize", 100)
+	viper.SetDefault("decisions.flushInterval", "1s")
+	viper.SetDefault("decisions.bufferSize", 10000)
+	viper.SetDefault("decisions.feed.maxLifetime", "1h")
+	viper.SetDefault("decisions.feed.idleTimeout", "5m")
+	viper.SetDefault("data.dynamodb.path", "dynamodb")
+	viper.SetDefault("data.dynamodb.keyAttribute", "id")
+	viper.SetDefault("data.dynamodb.refreshInterval", "1m")
+	viper.SetDefault("s3.maxConcurrentFetches", 4)
+	viper.SetDefault("s3.requestIdMetadataKey", "request-id")
+	viper.SetDefault("policy.query", "data.api.access.allow")
+	viper.SetDefault("policy.moduleName", "policy.rego")
+	viper.SetDefault("policy.templateRoot", "template")
+	viper.SetDefault("policy.target", "rego")
+	viper.SetDefault("policy.watch", true)
+	viper.SetDefault("policy.retainedRevisions", 3)
+	viper.SetDefault("policy.maxModules", 100)
+	viper.SetDefault("policy.maxDataBytes", 10<<20)
+	viper.SetDefault("policy.startupTimeout", "30s")
+	viper.SetDefault("log.decisions", true)
+	// Only settable through CONFIG_READATTEMPTS, CONFIG_RETRYBACKOFF and
+	// CONFIG_OVERLAY since they apply before the file is read.
+	viper.SetDefault("config.readAttempts", 5)
+	viper.SetDefault("config.retryBackoff", "500ms")
+
+	if err := readConfigWithRetry(viper.GetInt("config.readAttempts"), viper.GetDuration("config.retryBackoff")); err != nil {
 		panic(fmt.Errorf("fatal error config file: %w", err))
 	}
+
+	// An overlay such as CONFIG_OVERLAY=prod merges config.prod.yaml over
+	// the base file, its values taking precedence.
+	if overlay := viper.GetString("config.overlay"); overlay != "" {
+		viper.SetConfigName("config." + overlay)
+		if err := viper.MergeInConfig(); err != nil {
+			panic(fmt.Errorf("fatal error overlay config file config.%s.yaml: %w", overlay, err))
+		}
+	}
 }
 
-func main() {
+// readConfigWithRetry reads the config file, retrying with exponential
+// backoff while it does not exist yet, as when it is on a volume mounted
+// after the process starts. Other errors, such as invalid YAML, fail at once.
+func readConfigWithRetry(attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = viper.ReadInConfig()
+		var notFound viper.ConfigFileNotFoundError
+		if err == nil || !errors.As(err, &notFound) || attempt >= attempts {
+			return err
+		}
+		log.Printf("Config file not found (attempt %d of %d), retrying in %s", attempt, attempts, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
 
-	logger, _ := zap.NewProduction()
-	defer logger.Sync() // Flushes buffer, if any
-	sugar := logger.Sugar()
+func main() {
 
 	initConfig()
 
+	logger, err := newLogger()
+	if err != nil {
+		log.Fatalf("Failed to build logger: %v", err)
+	}
+	defer logger.Sync() // Flushes buffer on the graceful shutdown path
+	sugar = logger.Sugar()
+	registerMetrics()
+
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Fatalf("Error getting working directory: %v", err)
 	}
 	log.Printf("Working directory: %s", wd)
 
-	if err := loadAndPreparePolicy(context.Background()); err != nil {
-		sugar.Error("Failed to load or prepare policy", "error", err)
+	if err := validateLocalEndpoint(); err != nil {
+		sugar.Fatalw("Invalid S3 endpoint", "error", err)
+	}
+
+	awsConfig, err := loadAWSConfig(context.Background())
+	if err != nil {
+		sugar.Fatalw("Failed to load AWS config", "error", err)
+	}
+	sharedS3Client = newS3Client(awsConfig)
+
+	initS3FetchLimit(viper.GetInt("s3.maxConcurrentFetches"))
+	evalSlots = newEvalLimiter(viper.GetInt("evaluate.maxConcurrent"), viper.GetInt("evaluate.maxQueue"))
+
+	if err := loadInputSchema(); err != nil {
+		sugar.Fatalw("Invalid input schema", "error", err)
+	}
+
+	if err := loadResponseTemplate(); err != nil {
+		sugar.Fatalw("Invalid response template", "error", err)
+	}
+
+	if err := loadFieldAliases(); err != nil {
+		sugar.Fatalw("Invalid field aliases", "error", err)
+	}
+
+	// Generation is optional, so a bad template is reported but does not
+	// stop the service from evaluating.
+	if _, err := cachedPolicyTemplate(); err != nil {
+		sugar.Errorw("Policy template unavailable, generate-policy will fail", "error", err)
+	}
+
+	if err := loadInputTypes(); err != nil {
+		sugar.Fatalw("Invalid input types", "error", err)
+	}
+
+	if err := loadInputTransforms(); err != nil {
+		sugar.Fatalw("Invalid input transforms", "error", err)
+	}
+
+	// Background work that must stop once shutdown begins.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	if err := startDecisionStream(background); err != nil {
+		sugar.Fatalw("Failed to start decision stream", "error", err)
+	}
+
+	if err := startDynamoDataLoader(background, awsConfig); err != nil {
+		sugar.Fatalw("Failed to load DynamoDB data", "error", err)
+	}
+
+	loader, err := newPolicyLoader()
+	if err != nil {
+		sugar.Fatalw("Invalid policy loader configuration", "error", err)
+	}
+	dirLoader, isDir := loader.(*dirPolicyLoader)
+	// The cache holds a single policy, so multi-module loaders bypass it.
+	_, isModules := loader.(moduleLoader)
+	if ttl := viper.GetDuration("policy.cacheTTL"); ttl > 0 && !isModules {
+		loader = newCachingLoader(loader, ttl)
+	}
+
+	policyLoader = loader
+	if err := loadPolicyAtStartup(loader, viper.GetDuration("policy.startupTimeout")); errors.Is(err, context.DeadlineExceeded) {
+		sugar.Fatalw("Initial policy load timed out", "timeout", viper.GetDuration("policy.startupTimeout"), "error", err)
+	} else if err != nil {
+		sugar.Errorw("Failed to load or prepare policy", "error", err)
+	}
+	if interval := viper.GetDuration("policy.pollInterval"); interval > 0 {
+		inner := loader
+		if cached, ok := loader.(*cachingLoader); ok {
+			inner = cached.inner
+		}
+		if _, ok := inner.(s3PolicyLoader); ok {
+			go pollS3Policy(background, loader, interval)
+		} else {
+			sugar.Warnw("policy.pollInterval only applies to the single-object S3 loader, not polling")
+		}
+	}
+	if isDir && viper.GetBool("policy.watch") {
+		if err := watchPolicyDir(background, dirLoader); err != nil {
+			sugar.Errorw("Failed to watch policy directory", "error", err)
+		}
 	}
 	// Routes
-	http.HandleFunc("/evaluate", func(w http.ResponseWriter, r *http.Request) {
-		evaluatePolicyHandler(w, r, sugar)
-	})
-	http.HandleFunc("/generate-policy", func(w http.ResponseWriter, r *http.Request) {
-		generatePolicyHandler(w, r, sugar)
+	// Without tokens every route below is open, including the ones that
+	// overwrite policies in S3.
+	if !authEnabled() {
+		sugar.Warn("No auth.tokens or auth.adminTokens configured, all endpoints are unauthenticated")
+	}
+	http.HandleFunc("/evaluate", requireRole(roleBasic, requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		evaluatePolicyHandler(w, r, loggerFromContext(r.Context()))
+	})))
+	http.HandleFunc("/evaluate/batch", requireRole(roleBasic, requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		batchEvaluateHandler(w, r, loggerFromContext(r.Context()))
+	})))
+	http.HandleFunc("/explain", requireRole(roleBasic, requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		explainHandler(w, r, loggerFromContext(r.Context()))
+	})))
+	http.HandleFunc("/generate-policy/validate", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		validatePolicyHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/generate-policy/key-preview", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		keyPreviewHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/generate-policy", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			patchPolicyHandler(w, r, loggerFromContext(r.Context()))
+			return
+		}
+		generatePolicyHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/decisions/stream", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		decisionFeedHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.Handle("/metrics", metricsHandler())
+	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		statsHandler(w, r, loggerFromContext(r.Context()))
 	})
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/builtins", requireRole(roleBasic, func(w http.ResponseWriter, r *http.Request) {
+		builtinsHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/export-bundle", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		exportBundleHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/reload", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		reloadHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/admin/reload-template", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		reloadTemplateHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/admin/loglevel", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		logLevelHandler(w, r, loggerFromContext(r.Context()))
+	}))
+	http.HandleFunc("/admin/shutdown", requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		shutdownHandler(w, r, loggerFromContext(r.Context()))
+	}))
 
-	sugar.Info("Server started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	handler := withH2C(withRequestID(normalizeSlashes(http.DefaultServeMux)))
+
+	addr := viper.GetString("server.address")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		sugar.Fatalw("Invalid server.address, expected host:port", "address", addr, "error", err)
+	}
+	tlsConfig, certs, err := serverTLSConfig()
+	if err != nil {
+		sugar.Fatalw("Invalid TLS configuration", "error", err)
+	}
+	if certs != nil {
+		go reloadCertsOnSIGHUP(background, certs)
+	}
+	srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	srv.RegisterOnShutdown(feed.shutdown)
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		select {
+		case <-shutdownRequested():
+		case <-signals.Done():
+			markDraining()
+		}
+		stopBackground()
+		timeout := viper.GetDuration("server.shutdownTimeout")
+		sugar.Infow("Shutting down server", "timeout", timeout.String())
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			sugar.Errorw("Graceful shutdown failed", "error", err)
+		}
+		decisions.close(ctx)
+	}()
+
+	sugar.Infow("Server started", "address", addr, "tls", tlsConfig != nil)
+	serve := srv.ListenAndServe
+	if tlsConfig != nil {
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != http.ErrServerClosed {
+		sugar.Fatalw("Server failed", "error", err)
+	}
+	<-shutdownDone
+	sugar.Info("Server stopped")
+}
+
+// withH2C lets handler accept HTTP/2 over plaintext connections when
+// `server.http2` is set. TLS connections negotiate HTTP/2 through ALPN
+// without extra setup.
+func withH2C(handler http.Handler) http.Handler {
+	if !viper.GetBool("server.http2") {
+		return handler
+	}
+	return h2c.NewHandler(handler, &http2.Server{})
 }
 
 func evaluatePolicyHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	if r.Method != "POST" && r.Method != "GET" {
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var input map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+	// GET requests carry the input as ?input=<JSON object> so CDNs can key
+	// cached decisions on the URL.
+	reader := io.Reader(r.Body)
+	if r.Method == "GET" {
+		param := r.URL.Query().Get("input")
+		if param == "" {
+			http.Error(w, "GET requests need an input query parameter", http.StatusBadRequest)
+			return
+		}
+		reader = strings.NewReader(param)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(reader).Decode(&raw); err != nil {
+		logger.Errorw("Invalid JSON payload", "error", err)
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+	// Arrays, scalars and null are valid JSON but not an input document;
+	// batches of inputs go to /evaluate/batch.
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		http.Error(w, "Request body must be a JSON object", http.StatusBadRequest)
+		return
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
 		logger.Errorw("Invalid JSON payload", "error", err)
 		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
 		return
 	}
+	input, overrides := body, map[string]interface{}(nil)
+	if viper.GetBool("evaluate.dataOverrides") {
+		input, overrides = splitDataOverrides(body)
+	}
+	opaCompat := viper.GetBool("evaluate.opaCompat")
+	if opaCompat {
+		input, overrides = opaCompatInput(body), nil
+	}
+	input, err := prepareInput(r, input)
 
-	ctx := context.Background()
-		results, err := regoQuery.Eval(ctx, rego.EvalInput(input))
+	application, _ := input["applicationName"].(string)
+	decisionID := uuid.NewString()
+	logger = requestLogger(logger, r, application).With("decisionId", decisionID)
+	ctx, cancel := withRequestDeadline(contextWithLogger(r.Context(), logger), r)
+	defer cancel()
+	w.Header().Set("X-Decision-ID", decisionID)
 
 	if err != nil {
-		logger.Error("Failed to evaluate policy", zap.Error(err))
+		writeInputError(w, r, logger, err)
+		return
+	}
+	if overrides != nil {
+		if err := checkDataOverrides(r, overrides); err != nil {
+			logger.Warnw("Rejected data overrides", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Canary clients may pin one of the recently loaded revisions. Only the
+	// current revision's Wasm module is refreshed when data changes, so
+	// pinned revisions are decided by the interpreter.
+	pin := r.URL.Query().Get("revision")
+	policy := policies.Get()
+	pinned := policy != nil && pin != "" && pin != policy.revision
+	if !pinned {
+		countPreparedQueryLookup(policy != nil)
+	}
+	if policy == nil {
+		http.Error(w, "Policy not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	query := policy.query
+	if pinned {
+		pinned, ok := revisionPolicy(pin)
+		if !ok {
+			http.Error(w, "Unknown policy revision", http.StatusNotFound)
+			return
+		}
+		policy, query = pinned, pinned.interpretedQuery
+	}
+	revision := policy.revision
+	w.Header().Set("X-Policy-Revision", revision)
+
+	if viper.GetBool("evaluate.etag") || r.Method == "GET" {
+		etag, err := decisionETag(policy.revision, dataGeneration.Load(), responseVariant(r), input, overrides)
+		if err != nil {
+			logInternalError(logger, "Failed to compute ETag", err)
+			http.Error(w, "Failed to compute ETag", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		// The same URL is answered in JSON or protobuf depending on Accept.
+		w.Header().Set("Vary", "Accept")
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	refreshIfStale()
+
+	if !evalSlots.acquire(ctx) {
+		w.Header().Set("Retry-After", strconv.Itoa(viper.GetInt("evaluate.retryAfter")))
+		http.Error(w, "Too many concurrent evaluations", http.StatusTooManyRequests)
+		return
+	}
+	defer evalSlots.release()
+
+	// Only allow-listed query paths may be selected, so clients cannot
+	// probe internal rules.
+	if path := r.URL.Query().Get("query"); path != "" && path != allowQuery() {
+		selected, ok := policy.selectable[path]
+		if !ok {
+			http.Error(w, "Query path not allowed", http.StatusForbidden)
+			return
+		}
+		query = selected
+	}
+
+	if overrides != nil {
+		policy, err = overridePolicy(ctx, policy, overrides)
+		if err != nil {
+			logger.Warnw("Failed to apply data overrides", "error", err)
+			http.Error(w, "Failed to apply data overrides", http.StatusBadRequest)
+			return
+		}
+		query = policy.query
+	}
+
+	evaluated, err := Evaluate(ctx, query, input)
+
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logger.Warnw("Policy evaluation exceeded the request deadline", "error", err)
+		http.Error(w, "Policy evaluation exceeded the request deadline", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		logInternalError(logger, "Failed to evaluate policy", err)
 		http.Error(w, "Failed to evaluate policy", http.StatusInternalServerError)
 		return
 	}
 
-	if len(results) == 0 {
-		logger.Warn("No result from policy evaluation")
-		http.Error(w, "No result from policy evaluation", http.StatusInternalServerError)
+	// Every evaluation is logged and streamed, whatever format the response
+	// is written in.
+	decision, _ := evaluated.Allow()
+	recordDecision(logger, decisionID, revision, decision, evaluated.Result, input)
+	if opaCompat {
+		writeOPAResult(w, r, evaluated, decisionID)
 		return
 	}
 
-	// Assuming the decision is a boolean allow/deny
-	decision := results[0].Expressions[0].Value.(bool)
-	if decision {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Access granted"))
+	// An undefined entrypoint usually means the query path does not match
+	// the policy's package; optionally treat that as a deny, not an error.
+	var result interface{} = false
+	if !evaluated.Defined {
+		if !viper.GetBool("evaluate.undefinedAsDeny") {
+			logger.Warn("No result from policy evaluation")
+			http.Error(w, "No result from policy evaluation", http.StatusInternalServerError)
+			return
+		}
+		logger.Warnw("Policy entrypoint is undefined, denying by default", "query", allowQuery())
 	} else {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("Access denied"))
+		result = evaluated.Result
+	}
+
+	// Boolean results decide the status; any other value (a set of denial
+	// reasons, an object, ...) is returned as-is with 200.
+	_, isBool := result.(bool)
+
+	// Details requested by the client are added alongside the result.
+	resp := map[string]interface{}{"result": result}
+	if isBool {
+		resp["allow"] = decision
+	}
+	if isBool && decision {
+		if attributes, ok := allowedAttributes(ctx, policy, input); ok {
+			resp["attributes"] = attributes
+		}
 	}
+	if r.URL.Query().Get("requireReason") == "true" {
+		reason, err := decisionReason(ctx, policy, input)
+		if err != nil {
+			logger.Warnw("Policy did not provide a reason", "error", err)
+			http.Error(w, "Policy did not provide a reason for the decision", http.StatusInternalServerError)
+			return
+		}
+		resp["reason"] = reason
+	}
+	// Echoing is only offered to authenticated callers, and never includes
+	// the fields configured for redaction.
+	if r.URL.Query().Get("echoInput") == "true" && authEnabled() {
+		resp["input"] = redactInput(input, viper.GetStringSlice("log.redactFields"))
+	}
+	// Out-of-scope requests are a third outcome, so they get neither deny
+	// reasons nor a denial status.
+	status := http.StatusOK
+	notApplicable := decisionNotApplicable(ctx, policy, input)
+	if notApplicable {
+		resp["notApplicable"] = true
+		status = viper.GetInt("evaluate.notApplicableStatus")
+	} else if isBool && !decision {
+		if reasons := denyReasons(ctx, policy, input); reasons != nil {
+			resp["reasons"] = reasons
+		}
+		status = denialStatus(ctx, policy, input)
+		if scopes := requiredScopes(ctx, policy, input); len(scopes) > 0 {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+		}
+	}
+	setCacheHint(ctx, w, policy, input)
+	if r.Method == "GET" && status == http.StatusOK && isBool && decision {
+		setEdgeCacheHint(w)
+	}
+
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+
+	// The protobuf Decision message only carries boolean decisions; richer
+	// results and not-applicable outcomes are always returned as JSON.
+	if isBool && !notApplicable && wantsProtobuf(r) {
+		writeProtobufDecision(w, status, decision, decisionID, revision)
+		return
+	}
+
+	if responseTemplate != nil {
+		body, err := renderDecision(map[string]interface{}{
+			"allow":      decision,
+			"result":     result,
+			"decisionId": decisionID,
+		})
+		if err != nil {
+			logInternalError(logger, "Failed to render response template", err)
+			http.Error(w, "Failed to render response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", viper.GetString("evaluate.responseContentType"))
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+
+	writeJSON(w, r, status, resp)
+}
+
+// denialStatus returns the status for a denied input: 451 when the policy's
+// denial category is one of `evaluate.legalDenialCategories` (for example a
+// geo-block), 403 otherwise.
+func denialStatus(ctx context.Context, policy *loadedPolicy, input map[string]interface{}) int {
+	if policy.denialCategoryQuery == nil {
+		return http.StatusForbidden
+	}
+
+	results, err := policy.denialCategoryQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		loggerFromContext(ctx).Warnw("Failed to evaluate denial category query", "error", err)
+		return http.StatusForbidden
+	}
+	if len(results) == 0 {
+		return http.StatusForbidden
+	}
+
+	category, _ := results[0].Expressions[0].Value.(string)
+	for _, legal := range viper.GetStringSlice("evaluate.legalDenialCategories") {
+		if category == legal {
+			return http.StatusUnavailableForLegalReasons
+		}
+	}
+	return http.StatusForbidden
+}
+
+// decisionNotApplicable reports whether the optional
+// `evaluate.notApplicableQuery` is true for input, meaning the request falls
+// outside what the policy governs rather than being denied by it.
+func decisionNotApplicable(ctx context.Context, policy *loadedPolicy, input map[string]interface{}) bool {
+	if policy.notApplicableQuery == nil {
+		return false
+	}
+
+	results, err := policy.notApplicableQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		loggerFromContext(ctx).Warnw("Failed to evaluate not-applicable query", "error", err)
+		return false
+	}
+	if len(results) == 0 {
+		return false
+	}
+	notApplicable, _ := results[0].Expressions[0].Value.(bool)
+	return notApplicable
+}
+
+// decisionReason evaluates the configured reason query for input. It fails
+// when the policy does not define a reason for this decision.
+func decisionReason(ctx context.Context, policy *loadedPolicy, input map[string]interface{}) (interface{}, error) {
+	if policy.reasonQuery == nil {
+		return nil, errors.New("no reason query configured")
+	}
+	results, err := policy.reasonQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%s is undefined", viper.GetString("evaluate.reasonQuery"))
+	}
+	return results[0].Expressions[0].Value, nil
+}
+
+// denyReasons evaluates the configured deny query for a denied input and
+// returns its messages, sorted. It returns nil when no deny query is
+// configured or the policy does not define it.
+func denyReasons(ctx context.Context, policy *loadedPolicy, input map[string]interface{}) []string {
+	if policy.denyQuery == nil {
+		return nil
+	}
+	logger := loggerFromContext(ctx)
+
+	results, err := policy.denyQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		logger.Warnw("Failed to evaluate deny query", "error", err)
+		return nil
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	values, ok := results[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		logger.Warnw("Deny query did not return a set", "value", results[0].Expressions[0].Value)
+		return nil
+	}
+
+	reasons := []string{}
+	for _, v := range values {
+		if reason, ok := v.(string); ok {
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// requiredScopes evaluates the configured scopes query for a denied input.
+// It returns nil when no scopes query is configured or it is undefined.
+func requiredScopes(ctx context.Context, policy *loadedPolicy, input map[string]interface{}) []string {
+	if policy.scopesQuery == nil {
+		return nil
+	}
+	logger := loggerFromContext(ctx)
+
+	results, err := policy.scopesQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		logger.Warnw("Failed to evaluate scopes query", "error", err)
+		return nil
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	values, ok := results[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		logger.Warnw("Scopes query did not return a list", "value", results[0].Expressions[0].Value)
+		return nil
+	}
+
+	var scopes []string
+	for _, v := range values {
+		if scope, ok := v.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
 }
 
 func generatePolicyHandler(w http.ResponseWriter, r *http.Request, sugar *zap.SugaredLogger) {
@@ -122,76 +740,42 @@ func generatePolicyHandler(w http.ResponseWriter, r *http.Request, sugar *zap.Su
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	generateRequestsTotal.Inc()
 	var policyData PolicyData
 
 	if err := json.NewDecoder(r.Body).Decode(&policyData); err != nil {
 		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
 		return
 	}
-
-	// Fetch the policy template path from configuration
-	templatePath := viper.GetString("policy.templatePath")
-	bucketName := viper.GetString("s3.bucketName")
-	objectKey := fmt.Sprintf("policies/%s_%s_%s.rego", policyData.ApplicationName, policyData.ApiName, policyData.ApiVersion)
-
-	templateBytes, err := os.ReadFile(templatePath)
-	if err != nil {
-		log.Printf("Failed to read policy template file: %v", err)
-		http.Error(w, "Failed to read policy template file", http.StatusInternalServerError)
+	if errs := policyData.Validate(); errs != nil {
+		writeJSON(w, r, http.StatusBadRequest, map[string]interface{}{"errors": errs})
 		return
 	}
 
-	// tmpl, err := template.New("policy").Parse(string(templateBytes))
+	publishPolicy(w, r, policyData, requestLogger(sugar, r, policyData.ApplicationName))
+}
 
-	allowedActionsJSON, err := jsonMarshal(policyData.AllowedActions)
-	fmt.Println("AllowedActionsJSON:", allowedActionsJSON) // Should output: ["read","write"]
+// policyObjectKey returns the S3 key a generated policy is stored under.
+// Callers validate policyData first, so every component has passed
+// sanitizeKeyComponent.
+func policyObjectKey(policyData PolicyData) string {
+	return fmt.Sprintf("policies/%s_%s_%s.rego", policyData.ApplicationName, policyData.ApiName, policyData.ApiVersion)
+}
 
+// renderPolicy executes tmpl for policyData.
+func renderPolicy(tmpl *template.Template, policyData PolicyData) (bytes.Buffer, error) {
+	var filledPolicy bytes.Buffer
+	allowedActionsJSON, err := jsonMarshal(policyData.AllowedActions)
 	if err != nil {
-		log.Fatalf("Failed to marshal AllowedActions: %v", err)
+		return filledPolicy, fmt.Errorf("failed to marshal AllowedActions: %w", err)
 	}
 	allowedAttributesJSON, err := jsonMarshal(policyData.AllowedAttributes)
-	fmt.Println("AllowedAttributesJSON:", allowedAttributesJSON) // Should output: ["username","email"]
 	if err != nil {
-		log.Fatalf("Failed to marshal AllowedAttributes: %v", err)
-	}
-
-	// Include the JSON strings in your TemplateData struct
-	// templateData := struct {
-	// 	PolicyData
-	// 	AllowedActionsJSON    string
-	// 	AllowedAttributesJSON string
-	// }{
-	// 	PolicyData:            policyData,
-	// 	AllowedActionsJSON:    allowedActionsJSON,
-	// 	AllowedAttributesJSON: allowedAttributesJSON,
-	// }
-
-	// // Execute the template with the struct that includes the JSON strings.
-	// var filledPolicy bytes.Buffer
-	// tmpl, err := template.New("policy").Funcs(template.FuncMap{"jsonMarshal": jsonMarshal}).Parse(string(templateBytes)) // Assuming you have loaded your template into policyTemplateString.
-	// if err != nil {
-	// 	log.Fatalf("Failed to parse policy template: %v", err)
-	// }
-
-	// if err := tmpl.Execute(&filledPolicy, templateData); err != nil {
-	// 	log.Fatalf("Failed to execute policy template with data: %v", err)
-	// }
-	// if err != nil {
-	// 	log.Printf("Failed to parse policy template: %v", err)
-	// 	http.Error(w, "Failed to parse policy template", http.StatusInternalServerError)
-	// 	return
-	// }
-
-	// allowedActionsJSON, err := jsonMarshal(policyData.AllowedActions)
-	// if err != nil {
-	// 	log.Fatalf("Failed to marshal AllowedActions: %v", err)
-	// }
-
-	// allowedAttributesJSON, err := jsonMarshal(policyData.AllowedAttributes)
-	// if err != nil {
-	// 	log.Fatalf("Failed to marshal AllowedAttributes: %v", err)
-	// }
+		return filledPolicy, fmt.Errorf("failed to marshal AllowedAttributes: %w", err)
+	}
 
+	// Templates should call jsonMarshal themselves; the pre-marshalled
+	// fields remain for templates written before the function existed.
 	templateData := struct {
 		PolicyData
 		AllowedActionsJSON    string
@@ -202,133 +786,336 @@ func generatePolicyHandler(w http.ResponseWriter, r *http.Request, sugar *zap.Su
 		AllowedAttributesJSON: allowedAttributesJSON,
 	}
 
-	var filledPolicy bytes.Buffer
-	tmpl, err := template.New("policy").Parse(string(templateBytes))
-	fmt.Println("Template content:", string(templateBytes))
+	renderStart := time.Now()
+	if err := tmpl.Execute(&filledPolicy, templateData); err != nil {
+		return filledPolicy, fmt.Errorf("failed to execute policy template: %w", err)
+	}
+	generateRenderDuration.Observe(time.Since(renderStart).Seconds())
+	generatePolicySize.Observe(float64(filledPolicy.Len()))
+	return filledPolicy, nil
+}
+
+// checkGeneratedPolicy compiles a rendered policy and checks it defines the
+// allow query; compileErrors flattens the returned error.
+func checkGeneratedPolicy(objectKey, policy string) error {
+	compileStart := time.Now()
+	defer func() { generateCompileDuration.Observe(time.Since(compileStart).Seconds()) }()
 
+	if _, err := compilePolicy(context.Background(), objectKey, policy); err != nil {
+		return err
+	}
+	return checkQueryDefined(allowQuery(), map[string]string{objectKey: policy})
+}
+
+// publishPolicy renders policyData through the template, compiles the result
+// and uploads both the policy and its PolicyData sidecar to S3.
+func publishPolicy(w http.ResponseWriter, r *http.Request, policyData PolicyData, sugar *zap.SugaredLogger) {
+	bucketName := viper.GetString("s3.bucketName")
+	objectKey := policyObjectKey(policyData)
+
+	tmpl, err := cachedPolicyTemplate()
 	if err != nil {
-		log.Fatalf("Failed to parse policy template: %v", err)
+		sugar.Errorw("Failed to load policy template", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to load policy template: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	if err := tmpl.Execute(&filledPolicy, templateData); err != nil {
-		log.Fatalf("Failed to execute policy template with data: %v", err)
-	}
-	fmt.Println("Filled policy:", filledPolicy.String())
-	// var policy bytes.Buffer
-	// if err := tmpl.Execute(&policy, policyData); err != nil { // Use `data` instead of `templateBytes`
-	// 	log.Printf("Failed to execute template: %v", err)
-	// 	http.Error(w, "Failed to execute template", http.StatusInternalServerError)
-	// 	return
-	// }
-
-	// Initialize AWS S3 client and context remains the same
-
-	// Uploading the policy to S3
-	// Initialize AWS S3 client
-	ctx := context.Background()
-	// cfg, err := config.LoadDefaultConfig(ctx)
+	// Marshal and template failures stem from the submitted policy data, so
+	// they are reported to the client rather than taking the server down.
+	filledPolicy, err := renderPolicy(tmpl, policyData)
 	if err != nil {
-		log.Fatalf("Unable to load SDK config, %v", err)
+		sugar.Warnw("Failed to render policy", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// s3Client := s3.NewFromConfig(cfg)
-	// s3Client := s3.NewFromConfig(cfg)
-	s3Client := initS3Client(ctx)
+	// Refuse to upload a policy that the next load would reject, whether it
+	// fails to compile or does not define the configured allow query.
+	if err := checkGeneratedPolicy(objectKey, filledPolicy.String()); err != nil {
+		errs := compileErrors(err)
+		sugar.Warnw("Generated policy failed to compile", "objectKey", objectKey, "errors", errs)
+		writeJSON(w, r, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+		return
+	}
 
-	// Define the S3 bucket and object key
+	// A dry run previews the rendered policy while authoring templates,
+	// leaving S3 untouched.
+	if r.URL.Query().Get("dryRun") == "true" || r.Header.Get("X-Dry-Run") == "true" {
+		sugar.Infow("Rendered policy in dry-run mode", "objectKey", objectKey)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(filledPolicy.Bytes())
+		return
+	}
+	ctx := r.Context()
+	s3Client := sharedS3Client
 
-	// Upload the policy to S3
-	// _, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
-	// 	Bucket:      aws.String(bucketName), // Use the bucket name from config
-	// 	Key:         aws.String(objectKey),
-	// 	Body:        bytes.NewReader(policy.Bytes()),
-	// 	ContentType: aws.String("text/plain"),
-	// })
+	tagging, err := policyTagging(policyData)
+	if err != nil {
+		sugar.Errorw("Failed to render object tags", "error", err)
+		http.Error(w, "Failed to render object tags", http.StatusInternalServerError)
+		return
+	}
 
 	uploader := manager.NewUploader(s3Client)
-	_, err = uploader.Upload(context.TODO(), &s3.PutObjectInput{
+	uploadStart := time.Now()
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(objectKey),
 		Body:   bytes.NewReader(filledPolicy.Bytes()),
-	})
+	}
+	if tagging != "" {
+		input.Tagging = aws.String(tagging)
+	}
+	// The request id links the stored object to this request's log lines.
+	if key, id := viper.GetString("s3.requestIdMetadataKey"), requestIDFromContext(r.Context()); key != "" && id != "" {
+		input.Metadata = map[string]string{key: id}
+	}
+	_, err = uploader.Upload(ctx, input)
+	generateUploadDuration.Observe(time.Since(uploadStart).Seconds())
 
 	if err != nil {
-		log.Printf("Failed to upload policy to S3: %v", err)
+		sugar.Errorw("Failed to upload policy to S3", "objectKey", objectKey, "error", err)
 		http.Error(w, "Failed to upload policy to S3", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Policy successfully uploaded to S3: %s", objectKey)
+	if err := uploadPolicyData(ctx, s3Client, objectKey, policyData); err != nil {
+		sugar.Errorw("Failed to upload policy data to S3", "objectKey", objectKey, "error", err)
+		http.Error(w, "Failed to upload policy data to S3", http.StatusInternalServerError)
+		return
+	}
+
+	sugar.Infow("Policy successfully uploaded to S3", "objectKey", objectKey)
+	auditPolicyGenerated(sugar, requestActor(r), objectKey, filledPolicy.Bytes())
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Policy generated and uploaded to S3 successfully"))
 }
-func initS3Client(ctx context.Context) *s3.Client {
-	var cfg aws.Config
-	var err error
 
-	if viper.GetString("profile") != "local" {
-		cfg, err = config.LoadDefaultConfig(ctx)
-		if err != nil {
-			log.Fatalf("Unable to load SDK config, %v", err)
-		}
-		log.Printf("Using AWS profile: %s", viper.GetString("profile"))
+// sharedS3Client is the service's S3 client, built once in main so
+// credentials and region are resolved once per process rather than on every
+// fetch or upload.
+var sharedS3Client *s3.Client
 
-		// ...
-
-	} else {
+// newS3Client builds an S3 client from cfg, using path-style addressing so
+// LocalStack and MinIO work without DNS-style bucket hosts.
+func newS3Client(cfg aws.Config) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+}
 
-		cfg, err = config.LoadDefaultConfig(
+// loadAWSConfig loads the SDK config shared by the S3 and DynamoDB clients,
+// pointing every service at LocalStack under the local profile.
+func loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	if viper.GetString("profile") == "local" {
+		region, endpoint := localRegion(), localEndpoint()
+		log.Printf("Using local endpoint %s in region %s", endpoint, region)
+		cfg, err := config.LoadDefaultConfig(
 			ctx,
-			config.WithRegion("us-east-1"),
+			config.WithRegion(region),
 			config.WithEndpointResolverWithOptions(aws.EndpointResolverWithOptionsFunc(
 				func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-					return aws.Endpoint{URL: "http://localhost:4566"}, nil
+					return aws.Endpoint{URL: endpoint}, nil
 				}),
 			),
 		)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("unable to load SDK config: %w", err)
+		}
+		return cfg, nil
+	}
 
+	var opts []func(*config.LoadOptions) error
+	if region, source := resolveRegion(ctx); region != "" {
+		opts = append(opts, config.WithRegion(region))
+		log.Printf("Using AWS region %s from %s", region, source)
+	} else {
+		log.Printf("No AWS region configured, using SDK defaults")
 	}
 
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
-		log.Fatalf("Unable to load SDK config, %v", err)
+		return aws.Config{}, fmt.Errorf("unable to load SDK config: %w", err)
 	}
-	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.UsePathStyle = true
-	})
-	// AmazonS3Client client = new AmazonS3Client(new ClientConfiguration().withForcePathStyle(true));
-	return client
+	log.Printf("Using AWS profile: %s", viper.GetString("profile"))
+	return cfg, nil
+}
+
+// LocalStack defaults used by the local profile when `s3.region` or
+// `s3.endpoint` is empty.
+const (
+	defaultLocalRegion   = "us-east-1"
+	defaultLocalEndpoint = "http://localhost:4566"
+)
+
+// localRegion returns `s3.region` for the local profile, falling back to
+// LocalStack's default region.
+func localRegion() string {
+	if region := viper.GetString("s3.region"); region != "" {
+		return region
+	}
+	return defaultLocalRegion
 }
 
-func loadAndPreparePolicy(ctx context.Context) error {
-	policyString, err := fetchPolicyFromS3(ctx)
+// localEndpoint returns `s3.endpoint` for the local profile, falling back
+// to LocalStack's default port. Pointing it elsewhere allows another
+// LocalStack port or a MinIO instance.
+func localEndpoint() string {
+	if endpoint := viper.GetString("s3.endpoint"); endpoint != "" {
+		return endpoint
+	}
+	return defaultLocalEndpoint
+}
+
+// validateLocalEndpoint checks, under the local profile, that the endpoint
+// is an absolute URL, so a typo fails at startup instead of on the first
+// S3 call.
+func validateLocalEndpoint() error {
+	if viper.GetString("profile") != "local" {
+		return nil
+	}
+	endpoint := localEndpoint()
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("s3.endpoint %q: %w", endpoint, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("s3.endpoint %q must be an absolute URL like http://localhost:4566", endpoint)
+	}
+	return nil
+}
+
+// loadPolicyAtStartup runs the initial loadAndPreparePolicy bounded by
+// timeout. Loaders that ignore their context cannot hold up startup either:
+// the load keeps running in the background and its error is returned as one
+// wrapping context.DeadlineExceeded. A zero timeout waits forever.
+func loadPolicyAtStartup(loader PolicyLoader, timeout time.Duration) error {
+	if timeout <= 0 {
+		return loadAndPreparePolicy(context.Background(), loader)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- loadAndPreparePolicy(ctx, loader) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("policy not prepared within %s: %w", timeout, ctx.Err())
+	}
+}
+
+// policyLoads serializes loadAndPreparePolicy. Reloads come from /reload,
+// the directory watcher, S3 polling and the max-age refresh; without it two
+// of them could interleave, leaving one load's data in the store under the
+// other's queries.
+var policyLoads sync.Mutex
+
+// loadAndPreparePolicy fetches and compiles the policy, swapping it in only
+// when both steps succeed. On failure the previously loaded query, if any,
+// keeps serving.
+func loadAndPreparePolicy(ctx context.Context, loader PolicyLoader) error {
+	policyLoads.Lock()
+	defer policyLoads.Unlock()
+
+	set, err := loadPolicySet(ctx, loader)
 	if err != nil {
+		policyLoadFailuresTotal.Inc()
+		markFetchFailed()
+		if policies.Get() != nil {
+			sugar.Errorw("Policy reload failed, serving last-known-good policy", "error", err)
+		}
+		return err
+	}
+
+	modules := set.modules
+	if err := checkBundleLimits(modules, set.dataSize); err != nil {
+		policyLoadFailuresTotal.Inc()
 		return err
 	}
 
+	wasm, err := usesWasm()
+	if err != nil {
+		policyLoadFailuresTotal.Inc()
+		return err
+	}
 	// Assuming the policy does not require template processing
 	// If it does, insert template processing logic here before compiling
-	compiledQuery, err := rego.New(
-		rego.Query("data.api.access.allow"),
-		rego.Module("policy.rego", policyString),
-	).PrepareForEval(ctx)
-
+	compiledQuery, err := prepareQuery(ctx, allowQuery(), modules)
 	if err != nil {
+		policyLoadFailuresTotal.Inc()
+		if policies.Get() != nil {
+			sugar.Errorw("Policy reload failed to compile, serving last-known-good policy", "errors", compileErrors(err))
+		} else {
+			sugar.Errorw("Policy failed to compile", "errors", compileErrors(err))
+		}
 		return fmt.Errorf("failed to prepare rego query: %w", err)
 	}
+	if err := checkQueryDefined(allowQuery(), modules); err != nil {
+		policyLoadFailuresTotal.Inc()
+		return err
+	}
 
-	regoQuery = &compiledQuery
+	loaded := &loadedPolicy{
+		interpretedQuery: &compiledQuery,
+		modules:          modules,
+		data:             set.data,
+		revision:         computeRevision(modules),
+	}
+	if err := prepareAuxiliaryQueries(ctx, loaded); err != nil {
+		policyLoadFailuresTotal.Inc()
+		return err
+	}
+
+	// The interpreted allow query stays around for tracing even when Wasm
+	// decides requests.
+	allow := &compiledQuery
+	if wasm {
+		wasmQuery, err := prepareWasmQuery(ctx, modules, set.data)
+		if err != nil {
+			policyLoadFailuresTotal.Inc()
+			return fmt.Errorf("failed to prepare wasm query: %w", err)
+		}
+		allow = &wasmQuery
+	}
+
+	if err := replaceBundleData(ctx, set.data); err != nil {
+		policyLoadFailuresTotal.Inc()
+		return fmt.Errorf("failed to store policy data: %w", err)
+	}
+
+	loaded.query = allow
+	policies.Set(loaded)
+	retainRevision(loaded)
+	policyReloadsTotal.Inc()
+	policyLastReloadSuccess.SetToCurrentTime()
+	markPolicyLoaded()
+	markRefreshed()
 	return nil
 }
 
 func fetchPolicyFromS3(ctx context.Context) (string, error) {
-	s3Client := initS3Client(ctx)
+	if err := acquireS3Fetch(ctx); err != nil {
+		return "", fmt.Errorf("waiting for an S3 fetch slot: %w", err)
+	}
+	defer releaseS3Fetch()
+
+	s3Client := sharedS3Client
 	bucketName := viper.GetString("s3.bucketName")
 	policyObjectKey := viper.GetString("s3.policyObjectKey")
 
-	getObjResp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: &bucketName,
 		Key:    &policyObjectKey,
-	})
+	}
+	// A pinned version makes deployments reproducible; otherwise the latest
+	// version is fetched.
+	if versionID := viper.GetString("s3.policyVersionId"); versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	getObjResp, err := s3Client.GetObject(ctx, input)
 	if err != nil {
 		return "", fmt.Errorf("failed to get object from S3: %w", err)
 	}
@@ -339,16 +1126,46 @@ func fetchPolicyFromS3(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("failed to read policy body: %w", err)
 	}
 
+	if err := verifyChecksum(policyBytes, viper.GetString("s3.policySha256")); err != nil {
+		return "", err
+	}
+	setLoadedETag(aws.ToString(getObjResp.ETag))
+	sugar.Infow("Fetched policy object", "objectKey", policyObjectKey, "versionId", aws.ToString(getObjResp.VersionId), "etag", aws.ToString(getObjResp.ETag))
+
 	return string(policyBytes), nil
 }
 
+// verifyChecksum checks content against an expected hex-encoded sha256.
+// An empty expected value skips verification.
+func verifyChecksum(content []byte, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("policy checksum mismatch: expected sha256 %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// writeJSON writes v as a JSON response body with the given status code.
+// Output is indented when `response.pretty` is set or the request asks for
+// it with ?pretty=true.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	if viper.GetBool("response.pretty") || r.URL.Query().Get("pretty") == "true" {
+		enc.SetIndent("", "  ")
+	}
+	enc.Encode(v)
+}
+
 func jsonMarshal(v interface{}) (string, error) {
 	bytes, err := json.Marshal(v)
 	if err != nil {
 		return "", err // Return an empty string and the error if marshaling fails
 	}
-	txt := string(bytes)
-	escapeText := fmt.Sprintf("%q", txt)
-	fmt.Println("TST:", escapeText)
-	return escapeText, nil
+	return fmt.Sprintf("%q", string(bytes)), nil
 }
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/http2"
+)
+
+func TestReloadFailureKeepsLastKnownGoodPolicy(t *testing.T) {
+	good := loadTestPolicy(t, stringLoader(accessPolicy))
+	failures := readMetric(t, policyLoadFailuresTotal).GetCounter().GetValue()
+
+	broken := "package api.access\n\nimport rego.v1\n\nallow if input.role == undefined_role\n"
+	if err := loadAndPreparePolicy(context.Background(), stringLoader(broken)); err == nil {
+		t.Fatal("broken policy loaded")
+	}
+
+	if policies.Get() != good {
+		t.Error("broken reload replaced the last-known-good policy")
+	}
+	if got := readMetric(t, policyLoadFailuresTotal).GetCounter().GetValue() - failures; got != 1 {
+		t.Errorf("failure counter advanced by %v, want 1", got)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "admin"}`); rec.Code != http.StatusOK {
+		t.Errorf("status after failed reload = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+// scopedPolicy denies writes to non-admins and lists the scopes they lack.
+const scopedPolicy = accessPolicy + `
+required_scopes contains "policies:write" if input.action == "write"
+
+required_scopes contains "policies:admin" if input.action == "write"
+`
+
+func TestDeniedEvaluationListsRequiredScopes(t *testing.T) {
+	setConfig(t, "evaluate.scopesQuery", "data.api.access.required_scopes")
+	loadTestPolicy(t, stringLoader(scopedPolicy))
+
+	rec := postEvaluate("/evaluate", `{"role": "reader", "action": "write"}`)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", rec.Code, rec.Body)
+	}
+	want := `Bearer error="insufficient_scope", scope="policies:admin policies:write"`
+	if got := rec.Header().Get("WWW-Authenticate"); got != want {
+		t.Errorf("WWW-Authenticate = %q, want %q", got, want)
+	}
+
+	rec = postEvaluate("/evaluate", `{"role": "reader", "action": "read"}`)
+	if got := rec.Header().Get("WWW-Authenticate"); got != "" {
+		t.Errorf("allowed request got WWW-Authenticate %q", got)
+	}
+}
+
+func TestEnvironmentOverridesNestedConfigKeys(t *testing.T) {
+	if got := viper.GetString("s3.bucketName"); got != "abac-rego-policy" {
+		t.Fatalf("s3.bucketName = %q, want the config.yaml value", got)
+	}
+	t.Setenv("S3_BUCKETNAME", "bucket-from-env")
+	if got := viper.GetString("s3.bucketName"); got != "bucket-from-env" {
+		t.Errorf("s3.bucketName = %q, want S3_BUCKETNAME to override it", got)
+	}
+}
+
+func TestFetchPolicyVerifiesChecksum(t *testing.T) {
+	fake := useFakeS3(t)
+	fake.put(viper.GetString("s3.policyObjectKey"), accessPolicy)
+	sum := sha256.Sum256([]byte(accessPolicy))
+
+	setConfig(t, "s3.policySha256", strings.ToUpper(hex.EncodeToString(sum[:])))
+	got, err := fetchPolicyFromS3(context.Background())
+	if err != nil || got != accessPolicy {
+		t.Fatalf("fetch with a matching checksum = %q, %v", got, err)
+	}
+
+	setConfig(t, "s3.policySha256", strings.Repeat("0", 64))
+	if _, err := fetchPolicyFromS3(context.Background()); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("fetch with a mismatching checksum returned %v, want a checksum mismatch", err)
+	}
+}
+
+func TestHTTP2Cleartext(t *testing.T) {
+	setConfig(t, "server.http2", true)
+	srv := httptest.NewServer(withH2C(http.HandlerFunc(healthzHandler)))
+	defer srv.Close()
+
+	h2 := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := h2.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("HTTP/2 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Proto != "HTTP/2.0" {
+		t.Errorf("got %d over %s, want 200 over HTTP/2.0", resp.StatusCode, resp.Proto)
+	}
+
+	// HTTP/1.1 clients keep working alongside h2c.
+	resp, err = srv.Client().Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("HTTP/1.1 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/1.1" {
+		t.Errorf("plain client spoke %s, want HTTP/1.1", resp.Proto)
+	}
+}
+
+func TestEchoedInputIsRedacted(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "log.redactFields", []string{"user.password", "apiKey"})
+	body := `{"role": "admin", "apiKey": "k-123", "user": {"name": "ann", "password": "hunter2"}}`
+
+	setConfig(t, "auth.tokens", []string{"basic-key"})
+	req := httptest.NewRequest(http.MethodPost, "/evaluate?echoInput=true", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer basic-key")
+	rec := evaluateRequest(req)
+	var resp struct {
+		Input map[string]interface{} `json:"input"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, rec.Body)
+	}
+	user, _ := resp.Input["user"].(map[string]interface{})
+	if resp.Input["role"] != "admin" || user["name"] != "ann" {
+		t.Errorf("echoed input = %v, want unredacted fields kept", resp.Input)
+	}
+	if resp.Input["apiKey"] != redactedValue || user["password"] != redactedValue {
+		t.Errorf("echoed input = %v, want apiKey and user.password redacted", resp.Input)
+	}
+	if strings.Contains(rec.Body.String(), "hunter2") || strings.Contains(rec.Body.String(), "k-123") {
+		t.Errorf("secret leaked into response: %s", rec.Body)
+	}
+
+	// Without authentication the input is never echoed.
+	setConfig(t, "auth.tokens", []string{})
+	rec = postEvaluate("/evaluate?echoInput=true", body)
+	if strings.Contains(rec.Body.String(), `"input"`) {
+		t.Errorf("input echoed with authentication disabled: %s", rec.Body)
+	}
+}
+
+func TestPrettyJSONResponses(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	compact := "{\"allow\":true,\"result\":true}\n"
+	indented := "{\n  \"allow\": true,\n  \"result\": true\n}\n"
+
+	setConfig(t, "response.pretty", false)
+	if got := postEvaluate("/evaluate", `{"role": "admin"}`).Body.String(); got != compact {
+		t.Errorf("default body = %q, want %q", got, compact)
+	}
+	if got := postEvaluate("/evaluate?pretty=true", `{"role": "admin"}`).Body.String(); got != indented {
+		t.Errorf("?pretty=true body = %q, want %q", got, indented)
+	}
+	setConfig(t, "response.pretty", true)
+	if got := postEvaluate("/evaluate", `{"role": "admin"}`).Body.String(); got != indented {
+		t.Errorf("response.pretty body = %q, want %q", got, indented)
+	}
+}
+
+func TestQuerySelectionIsAllowListed(t *testing.T) {
+	src := accessPolicy + "\nis_admin if input.role == \"admin\"\n\ninternal_secret := \"s3cr3t\"\n"
+	setConfig(t, "evaluate.allowedQueries", []string{"data.api.access.is_admin"})
+	loadTestPolicy(t, stringLoader(src))
+
+	rec := postEvaluate("/evaluate?query=data.api.access.is_admin", `{"role": "admin"}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("allow-listed query status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	rec = postEvaluate("/evaluate?query=data.api.access.internal_secret", `{"role": "admin"}`)
+	if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "s3cr3t") {
+		t.Errorf("non-allow-listed query: got %d %q, want 403 without the value", rec.Code, rec.Body)
+	}
+}
+
+func TestRequireReason(t *testing.T) {
+	// accessPolicy has no reason rule, so a required reason is an error.
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	rec := postEvaluate("/evaluate?requireReason=true", `{"role": "admin"}`)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "did not provide a reason") {
+		t.Errorf("missing reason: got %d %q, want 500", rec.Code, rec.Body)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "admin"}`); rec.Code != http.StatusOK {
+		t.Errorf("status without requireReason = %d, want 200", rec.Code)
+	}
+
+	explained := accessPolicy + "\nreason := \"admins may do anything\" if input.role == \"admin\"\n"
+	loadTestPolicy(t, stringLoader(explained))
+	rec = postEvaluate("/evaluate?requireReason=true", `{"role": "admin"}`)
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Reason != "admins may do anything" {
+		t.Errorf("got %d %s, want the policy's reason", rec.Code, rec.Body)
+	}
+}
+
+func TestGeoBlockedDenialIs451(t *testing.T) {
+	src := accessPolicy + `
+denial_category := "geo_blocked" if input.country == "XX"
+
+denial_category := "forbidden" if {
+	not input.country == "XX"
+	not allow
+}
+`
+	setConfig(t, "evaluate.denialCategoryQuery", "data.api.access.denial_category")
+	setConfig(t, "evaluate.legalDenialCategories", []string{"geo_blocked"})
+	loadTestPolicy(t, stringLoader(src))
+
+	if rec := postEvaluate("/evaluate", `{"role": "guest", "country": "XX"}`); rec.Code != http.StatusUnavailableForLegalReasons {
+		t.Errorf("geo-blocked status = %d, want 451", rec.Code)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "guest", "country": "FR"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("other denial status = %d, want 403", rec.Code)
+	}
+}
+
+// useConfigDir points viper at an empty config search path in dir,
+// restoring the repository config when the test ends.
+func useConfigDir(t *testing.T, dir string) {
+	t.Helper()
+	viper.Reset()
+	viper.AddConfigPath(dir)
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		viper.Reset()
+		initConfig()
+	})
+}
+
+func TestReadConfigWaitsForDelayedFile(t *testing.T) {
+	dir := t.TempDir()
+	useConfigDir(t, dir)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("probe: mounted\n"), 0o600)
+	}()
+	if err := readConfigWithRetry(6, 25*time.Millisecond); err != nil {
+		t.Fatalf("config never read: %v", err)
+	}
+	if got := viper.GetString("probe"); got != "mounted" {
+		t.Errorf("probe = %q, want the delayed file's value", got)
+	}
+}
+
+func TestReadConfigGivesUpAfterAttempts(t *testing.T) {
+	useConfigDir(t, t.TempDir())
+
+	start := time.Now()
+	err := readConfigWithRetry(3, 10*time.Millisecond)
+	var notFound viper.ConfigFileNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("err = %v, want ConfigFileNotFoundError", err)
+	}
+	// Two waits of 10ms and 20ms separate the three attempts.
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("gave up after %s, want the backoff to double between attempts", elapsed)
+	}
+}
+
+func TestUndefinedEntrypointDefaultDeny(t *testing.T) {
+	// Without a default the allow rule is undefined for guests.
+	loadTestPolicy(t, stringLoader("package api.access\n\nimport rego.v1\n\nallow if input.role == \"admin\"\n"))
+
+	setConfig(t, "evaluate.undefinedAsDeny", false)
+	if rec := postEvaluate("/evaluate", `{"role": "guest"}`); rec.Code != http.StatusInternalServerError {
+		t.Errorf("undefined decision status = %d, want 500", rec.Code)
+	}
+
+	setConfig(t, "evaluate.undefinedAsDeny", true)
+	rec := postEvaluate("/evaluate", `{"role": "guest"}`)
+	if rec.Code != http.StatusForbidden || strings.TrimSpace(rec.Body.String()) != `{"allow":false,"result":false}` {
+		t.Errorf("undefined decision with default deny: got %d %s, want 403 deny", rec.Code, rec.Body)
+	}
+	if rec := postEvaluate("/evaluate", `{"role": "admin"}`); rec.Code != http.StatusOK {
+		t.Errorf("defined decision status = %d, want 200", rec.Code)
+	}
+}
+
+func TestConfigOverlayTakesPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("s3:\n  bucketName: base-bucket\n  region: us-east-1\n"), 0o600)
+	os.WriteFile(filepath.Join(dir, "config.prod.yaml"), []byte("s3:\n  bucketName: prod-bucket\n"), 0o600)
+	useConfigDir(t, dir)
+	t.Setenv("CONFIG_OVERLAY", "prod")
+
+	initConfig()
+	if got := viper.GetString("s3.bucketName"); got != "prod-bucket" {
+		t.Errorf("s3.bucketName = %q, want the overlay value", got)
+	}
+	// Keys the overlay leaves out keep their base values.
+	if got := viper.GetString("s3.region"); got != "us-east-1" {
+		t.Errorf("s3.region = %q, want the base value", got)
+	}
+}
+
+// scopedOutPolicy is reasonedPolicy with payroll resources out of scope.
+const scopedOutPolicy = reasonedPolicy + `
+not_applicable if input.resource == "payroll"
+`
+
+func TestNotApplicableDecision(t *testing.T) {
+	setConfig(t, "evaluate.notApplicableQuery", "data.api.access.not_applicable")
+	setConfig(t, "evaluate.notApplicableStatus", http.StatusOK)
+	loadTestPolicy(t, stringLoader(scopedOutPolicy))
+
+	rec := postEvaluate("/evaluate", `{"role": "guest", "resource": "payroll"}`)
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp["notApplicable"] != true || resp["reasons"] != nil {
+		t.Errorf("out-of-scope request = %d %s, want 200 with notApplicable and no reasons", rec.Code, rec.Body)
+	}
+
+	rec = postEvaluate("/evaluate", `{"role": "guest", "resource": "invoices"}`)
+	resp = nil
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusForbidden || resp["notApplicable"] != nil {
+		t.Errorf("in-scope denial = %d %s, want a plain 403", rec.Code, rec.Body)
+	}
+
+	setConfig(t, "evaluate.notApplicableStatus", http.StatusNoContent)
+	if rec := postEvaluate("/evaluate", `{"role": "guest", "resource": "payroll"}`); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("out-of-scope request with 204 mapping = %d %q, want an empty 204", rec.Code, rec.Body)
+	}
+}
+
+func TestRequestsShareOneS3Client(t *testing.T) {
+	fake := useFakeS3(t)
+	client := sharedS3Client
+	fake.put(viper.GetString("s3.policyObjectKey"), accessPolicy)
+
+	generatePolicy(t, samplePolicyData)
+	generatePolicy(t, samplePolicyData)
+	if _, err := (s3PolicyLoader{}).Load(context.Background()); err != nil {
+		t.Fatalf("policy fetch failed: %v", err)
+	}
+	if code, _ := previewKey(t, samplePolicyData); code != http.StatusOK {
+		t.Fatalf("key preview status = %d", code)
+	}
+
+	// Every call went through the one client built up front, so they all
+	// reused its pooled connection; a client built per request would have
+	// opened its own.
+	if sharedS3Client != client {
+		t.Error("a request replaced the shared S3 client")
+	}
+	if fake.downloads() != 1 || fake.uploadHeaders(billingPolicyKey) == nil {
+		t.Errorf("fake S3 served %d downloads and no upload, want every call on the shared client", fake.downloads())
+	}
+	if got := fake.connections(); got != 1 {
+		t.Errorf("S3 calls opened %d connections, want 1 from the shared client's pool", got)
+	}
+}
+
+func TestLocalProfileUsesConfiguredEndpoint(t *testing.T) {
+	fake := &fakeS3{objects: map[string]fakeObject{}, puts: map[string]http.Header{}, gets: map[string]int{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	fake.put("policies/local.rego", accessPolicy)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	setConfig(t, "profile", "local")
+	setConfig(t, "s3.region", "eu-west-1")
+	setConfig(t, "s3.endpoint", srv.URL)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	cfg, err := loadAWSConfig(context.Background())
+	if err != nil {
+		t.Fatalf("loadAWSConfig() = %v", err)
+	}
+	if cfg.Region != "eu-west-1" {
+		t.Errorf("region = %q, want the configured local region", cfg.Region)
+	}
+	body, err := fetchS3Object(context.Background(), newS3Client(cfg), viper.GetString("s3.bucketName"), "policies/local.rego")
+	if err != nil || string(body) != accessPolicy {
+		t.Errorf("fetch through the local endpoint = %q, %v", body, err)
+	}
+}
+
+func TestEvaluateRejectsNonObjectBodies(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	for _, body := range []string{`[{"role": "admin"}]`, `"admin"`, `42`, `true`, `null`} {
+		rec := postEvaluate("/evaluate", body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Request body must be a JSON object") {
+			t.Errorf("%s: got %d %q, want 400 asking for a JSON object", body, rec.Code, rec.Body)
+		}
+	}
+	if rec := postEvaluate("/evaluate", `{"role": `); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Invalid JSON payload") {
+		t.Errorf("truncated body: got %d %q, want 400 invalid JSON", rec.Code, rec.Body)
+	}
+}
+
+func TestRequestIDStoredInUploadMetadata(t *testing.T) {
+	fake := useFakeS3(t)
+	generate := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		generatePolicyHandler(w, r, sugar)
+	}))
+	upload := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/generate-policy", strings.NewReader(samplePolicyData))
+		req.Header.Set(requestIDHeader, "req-8f14e45f")
+		rec := httptest.NewRecorder()
+		generate.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("generate status = %d: %s", rec.Code, rec.Body)
+		}
+		return fake.uploadHeaders(billingPolicyKey).Get("X-Amz-Meta-Correlation-Id")
+	}
+
+	setConfig(t, "s3.requestIdMetadataKey", "correlation-id")
+	if got := upload(); got != "req-8f14e45f" {
+		t.Errorf("correlation-id metadata = %q, want the request id", got)
+	}
+	setConfig(t, "s3.requestIdMetadataKey", "")
+	if got := upload(); got != "" {
+		t.Errorf("metadata = %q with the key unset, want none", got)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// shutdownSignal holds the channel closed when the server should shut down.
+// The mutex lets tests swap in a fresh channel while a scheduled
+// requestShutdown may still be running.
+type shutdownSignal struct {
+	mu     sync.Mutex
+	ch     chan struct{}
+	closed bool
+}
+
+var shutdownState = &shutdownSignal{ch: make(chan struct{})}
+
+// shutdownRequested returns the channel closed once shutdown is requested.
+func shutdownRequested() <-chan struct{} {
+	shutdownState.mu.Lock()
+	defer shutdownState.mu.Unlock()
+	return shutdownState.ch
+}
+
+// requestShutdown asks main to shut the server down gracefully.
+func requestShutdown() {
+	shutdownState.mu.Lock()
+	defer shutdownState.mu.Unlock()
+	if !shutdownState.closed {
+		close(shutdownState.ch)
+		shutdownState.closed = true
+	}
+}
+
+// shutdownHandler marks the service as not ready so load balancers stop
+// routing to it, then starts a graceful shutdown after
+// `server.shutdownDelay`, giving them time to notice.
+func shutdownHandler(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	delay := viper.GetDuration("server.shutdownDelay")
+	if !markDraining() {
+		http.Error(w, "Shutdown already scheduled", http.StatusConflict)
+		return
+	}
+	logger.Infow("Shutdown requested, draining", "delay", delay.String(), "actor", requestActor(r))
+	time.AfterFunc(delay, requestShutdown)
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Shutdown scheduled in %s", delay)
+}
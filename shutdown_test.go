@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useShutdownSignal gives the test its own shutdown channel.
+func useShutdownSignal(t *testing.T) <-chan struct{} {
+	t.Helper()
+	swap := func(ch chan struct{}, closed bool) (chan struct{}, bool) {
+		shutdownState.mu.Lock()
+		defer shutdownState.mu.Unlock()
+		previous, wasClosed := shutdownState.ch, shutdownState.closed
+		shutdownState.ch, shutdownState.closed = ch, closed
+		return previous, wasClosed
+	}
+	previous, wasClosed := swap(make(chan struct{}), false)
+	t.Cleanup(func() { swap(previous, wasClosed) })
+	return shutdownRequested()
+}
+
+func shutdownRequest() *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	shutdownHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/shutdown", nil), sugar)
+	return rec
+}
+
+func TestShutdownDrainsThenSchedulesShutdown(t *testing.T) {
+	resetReadiness(t)
+	setConfig(t, "server.readyGracePeriod", "0s")
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	requested := useShutdownSignal(t)
+	setConfig(t, "server.shutdownDelay", "50ms")
+
+	if got := readyzStatus(); got != http.StatusOK {
+		t.Fatalf("status before shutdown = %d, want 200", got)
+	}
+	if rec := shutdownRequest(); rec.Code != http.StatusAccepted {
+		t.Fatalf("shutdown status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+	if got := readyzStatus(); got != http.StatusServiceUnavailable {
+		t.Errorf("status while draining = %d, want 503", got)
+	}
+
+	select {
+	case <-requested:
+		t.Fatal("shutdown started before the delay")
+	case <-time.After(20 * time.Millisecond):
+	}
+	select {
+	case <-requested:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown never requested")
+	}
+
+	if rec := shutdownRequest(); rec.Code != http.StatusConflict {
+		t.Errorf("second shutdown status = %d, want 409", rec.Code)
+	}
+}
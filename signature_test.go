@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// sign returns the X-Signature value for payload under secret.
+func sign(payload, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedEvaluate runs req through requireSignature and the evaluate handler.
+func signedEvaluate(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		evaluatePolicyHandler(w, r, sugar)
+	})(rec, req)
+	return rec
+}
+
+func TestSignedEvaluateRequests(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.hmacSecret", "shared-secret")
+	body := `{"role": "admin"}`
+
+	cases := []struct {
+		name, body, signature string
+		want                  int
+	}{
+		{"valid", body, sign(body, "shared-secret"), http.StatusOK},
+		{"valid without prefix", body, strings.TrimPrefix(sign(body, "shared-secret"), "sha256="), http.StatusOK},
+		{"tampered body", `{"role": "admin", "x": 1}`, sign(body, "shared-secret"), http.StatusUnauthorized},
+		{"wrong secret", body, sign(body, "other-secret"), http.StatusUnauthorized},
+		{"missing", body, "", http.StatusUnauthorized},
+		{"not hex", body, "sha256=zz", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(c.body))
+		if c.signature != "" {
+			req.Header.Set(signatureHeader, c.signature)
+		}
+		// A valid signature hands the full body on to the handler.
+		if got := signedEvaluate(req).Code; got != c.want {
+			t.Errorf("%s signature: status = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestUnsignedRequestsPassWithoutSecret(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.hmacSecret", "")
+	req := httptest.NewRequest(http.MethodPost, "/evaluate", strings.NewReader(`{"role": "admin"}`))
+	if got := signedEvaluate(req).Code; got != http.StatusOK {
+		t.Errorf("status = %d, want 200", got)
+	}
+}
+
+func TestSignedGetEvaluateSignsInputParameter(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	setConfig(t, "evaluate.hmacSecret", "shared-secret")
+	input := `{"role": "admin"}`
+
+	for signed, want := range map[string]int{input: http.StatusOK, `{"role": "guest"}`: http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/evaluate?input="+url.QueryEscape(input), nil)
+		req.Header.Set(signatureHeader, sign(signed, "shared-secret"))
+		if got := signedEvaluate(req).Code; got != want {
+			t.Errorf("signature over %s: status = %d, want %d", signed, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// normalizeSlashes applies `server.trailingSlash` to request paths with a
+// trailing slash or repeated slashes, such as /evaluate/ or //evaluate:
+// "strip" (default) serves them as the cleaned path, "redirect" answers 308
+// to the cleaned path, and "strict" leaves them to the mux unchanged.
+func normalizeSlashes(next http.Handler) http.Handler {
+	mode := viper.GetString("server.trailingSlash")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleaned := cleanSlashes(r.URL.Path)
+		if mode == "strict" || cleaned == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if mode == "redirect" {
+			target := *r.URL
+			target.Path = cleaned
+			target.RawPath = ""
+			http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = cleaned
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// cleanSlashes collapses repeated slashes in p and drops a trailing one,
+// keeping the root path "/".
+func cleanSlashes(p string) string {
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
+}
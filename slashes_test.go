@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// routeEvaluate serves /evaluate behind normalizeSlashes, as main does.
+func routeEvaluate(t *testing.T, mode, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	setConfig(t, "server.trailingSlash", mode)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/evaluate", func(w http.ResponseWriter, r *http.Request) {
+		evaluatePolicyHandler(w, r, sugar)
+	})
+	rec := httptest.NewRecorder()
+	normalizeSlashes(mux).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"role": "admin"}`)))
+	return rec
+}
+
+func TestTrailingSlashesServeTheSameRoute(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	for _, path := range []string{"/evaluate", "/evaluate/", "//evaluate", "/evaluate//"} {
+		if rec := routeEvaluate(t, "strip", path); rec.Code != http.StatusOK {
+			t.Errorf("POST %s: status = %d, want 200", path, rec.Code)
+		}
+	}
+}
+
+func TestTrailingSlashModes(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+
+	rec := routeEvaluate(t, "redirect", "/evaluate/?query=data.api.access.allow")
+	if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != "/evaluate?query=data.api.access.allow" {
+		t.Errorf("redirect mode = %d to %q, want 308 to the cleaned path", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := routeEvaluate(t, "strict", "/evaluate/"); rec.Code != http.StatusNotFound {
+		t.Errorf("strict mode status = %d, want 404", rec.Code)
+	}
+	if rec := routeEvaluate(t, "strict", "/evaluate"); rec.Code != http.StatusOK {
+		t.Errorf("strict mode status for the exact path = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// policyTagging renders the `s3.tags` map into the URL-encoded tag set S3
+// expects in PutObjectInput.Tagging. Each value is a template evaluated
+// against the policy data, e.g. owner: "{{.ClientID}}". Tags that render
+// empty are left out; an empty result means the object is not tagged.
+func policyTagging(pd PolicyData) (string, error) {
+	tags := url.Values{}
+	for key, src := range viper.GetStringMapString("s3.tags") {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(src)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse tag %q: %w", key, err)
+		}
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, pd); err != nil {
+			return "", fmt.Errorf("failed to render tag %q: %w", key, err)
+		}
+		if value.Len() > 0 {
+			tags.Set(key, value.String())
+		}
+	}
+	return tags.Encode(), nil
+}
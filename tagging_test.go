@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// generatePolicy posts body to /generate-policy, uploading the result.
+func generatePolicy(t *testing.T, body string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	generatePolicyHandler(rec, httptest.NewRequest(http.MethodPost, "/generate-policy", strings.NewReader(body)), sugar)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("generate status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUploadedPoliciesAreTagged(t *testing.T) {
+	fake := useFakeS3(t)
+	setConfig(t, "s3.tags", map[string]interface{}{
+		"app":   "{{.ApplicationName}}",
+		"env":   "{{.Environment}}",
+		"owner": "{{.ClientID}}",
+		"team":  "",
+	})
+
+	generatePolicy(t, samplePolicyData)
+	tags, err := url.ParseQuery(fake.uploadHeaders(billingPolicyKey).Get("X-Amz-Tagging"))
+	if err != nil {
+		t.Fatalf("invalid tagging header: %v", err)
+	}
+	want := url.Values{"app": {"billing"}, "env": {"prod"}, "owner": {"client-1"}}
+	if tags.Encode() != want.Encode() {
+		t.Errorf("tags = %q, want %q", tags.Encode(), want.Encode())
+	}
+}
+
+func TestPolicyTaggingRejectsUnknownFields(t *testing.T) {
+	setConfig(t, "s3.tags", map[string]interface{}{"owner": "{{.Owner}}"})
+	if _, err := policyTagging(PolicyData{ApplicationName: "billing"}); err == nil {
+		t.Error("tag referencing an unknown field rendered")
+	}
+}
@@ -2,23 +2,15 @@ package api.access
 
 import rego.v1
 
-default allow = false
+default allow := false
 
-# Main rule to determine if access should be allowed
-
-allow if {
-    allowed_client
-    allowed_client_application
-    allowed_client_application_attributes
-}
+# Allowed actions and attributes are embedded as JSON strings and decoded once
+allowed_actions := json.unmarshal({{ jsonMarshal .AllowedActions }})
 
-allowed_client if 
+allowed_attrs := json.unmarshal({{ jsonMarshal .AllowedAttributes }})
 
-allow {
-    # Dynamically unmarshal the allowed actions and attributes at the point of use
-    allowed_actions := json.unmarshal({{.AllowedActionsJSON}})
-    allowed_attrs := json.unmarshal({{.AllowedAttributesJSON}})
-    
+# Main rule to determine if access should be allowed
+allow if {
     input.applicationName == "{{ .ApplicationName }}"
     input.environment == "{{ .Environment }}"
     input.clientID == "{{ .ClientID }}"
@@ -29,19 +21,13 @@ allow {
 }
 
 # Validate if the requested action is allowed
-actions_allowed(action) {
-    allowed_actions := json.unmarshal({{.AllowedActionsJSON}})
-    action == allowed_actions[_]
-}
-    
-    # Validate if all requested attributes are allowed
-attributes_allowed(requested) {
-allowed_attrs := json.unmarshal({{.AllowedAttributesJSON}})
-count(requested) == count({attr | attr := requested[_]; attr_allowed(attr)})
+actions_allowed(action) if {
+    action in allowed_actions
 }
 
-# Helper to check individual attribute
-attr_allowed(attr) {
-allowed_attrs := json.unmarshal({{ .AllowedAttributesJSON }})
-attr == allowed_attrs[_]
+# Validate if all requested attributes are allowed
+attributes_allowed(requested) if {
+    every attr in requested {
+        attr in allowed_attrs
+    }
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/viper"
+)
+
+// certReloader serves the server certificate and re-reads it from disk on
+// demand, so rotated certificates apply without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// reload reads the key pair again. On failure the previous certificate
+// keeps being served.
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reloadCertsOnSIGHUP reloads the server certificate every time the process
+// receives SIGHUP, until ctx is cancelled.
+func reloadCertsOnSIGHUP(ctx context.Context, certs *certReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if err := certs.reload(); err != nil {
+			sugar.Errorw("Certificate reload failed, keeping the current certificate", "error", err)
+			continue
+		}
+		sugar.Infow("Reloaded server certificate", "certFile", certs.certFile)
+	}
+}
+
+// serverTLSConfig builds the listener's TLS config from `server.tls`. TLS is
+// on when `server.tls.enabled` is set or a certificate is configured, and
+// then both certFile and keyFile must be readable, so a bad setup fails at
+// startup. It returns nil when TLS is off, in which case the server speaks
+// plain HTTP. With `server.tls.clientCAFile` set every client must present
+// a certificate signed by that CA (mutual TLS). The returned certReloader
+// re-reads the certificate for rotation.
+func serverTLSConfig() (*tls.Config, *certReloader, error) {
+	certFile := viper.GetString("server.tls.certFile")
+	keyFile := viper.GetString("server.tls.keyFile")
+	caFile := viper.GetString("server.tls.clientCAFile")
+	if !viper.GetBool("server.tls.enabled") && certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, nil, fmt.Errorf("server.tls.clientCAFile requires server.tls.certFile and server.tls.keyFile")
+		}
+		return nil, nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, nil, fmt.Errorf("TLS requires both server.tls.certFile and server.tls.keyFile")
+	}
+
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := certs.reload(); err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{
+		GetCertificate: certs.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, certs, nil
+}
+
+// clientCertInput describes the verified client certificate of r for use by
+// policies as input.clientCert, or nil when the client presented none.
+func clientCertInput(r *http.Request) map[string]interface{} {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	dnsNames := make([]interface{}, len(cert.DNSNames))
+	for i, name := range cert.DNSNames {
+		dnsNames[i] = name
+	}
+	return map[string]interface{}{
+		"subject":    cert.Subject.String(),
+		"commonName": cert.Subject.CommonName,
+		"dnsNames":   dnsNames,
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for commonName, valid for
+// usage, signed by the CA.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"payments"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writePEM(t *testing.T, dir, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const clientCertPolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if input.clientCert.commonName == "billing-service"
+`
+
+func TestMutualTLSClientCertificates(t *testing.T) {
+	loadTestPolicy(t, stringLoader(clientCertPolicy))
+	dir := t.TempDir()
+	ca := newTestCA(t, "mesh-ca")
+	serverCert, serverKey := ca.issue(t, "policy-service", x509.ExtKeyUsageServerAuth)
+	setConfig(t, "server.tls.certFile", writePEM(t, dir, "server.pem", serverCert))
+	setConfig(t, "server.tls.keyFile", writePEM(t, dir, "server-key.pem", serverKey))
+	setConfig(t, "server.tls.clientCAFile", writePEM(t, dir, "ca.pem", ca.pem))
+
+	cfg, _, err := serverTLSConfig()
+	if err != nil {
+		t.Fatalf("serverTLSConfig() = %v", err)
+	}
+	// httptest's StartTLS would add its own certificate ahead of cfg's.
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			evaluatePolicyHandler(w, r, sugar)
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+	url := "https://" + ln.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	post := func(certPEM, keyPEM []byte) (*http.Response, error) {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if certPEM != nil {
+			pair, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		defer client.CloseIdleConnections()
+		return client.Post(url+"/evaluate", "application/json", strings.NewReader(`{"clientCert": {"commonName": "billing-service"}}`))
+	}
+
+	// The policy sees the verified subject, not what the body claims.
+	for name, want := range map[string]int{"billing-service": http.StatusOK, "reporting-service": http.StatusForbidden} {
+		resp, err := post(ca.issue(t, name, x509.ExtKeyUsageClientAuth))
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status = %d, want %d", name, resp.StatusCode, want)
+		}
+	}
+
+	if resp, err := post(newTestCA(t, "rogue-ca").issue(t, "billing-service", x509.ExtKeyUsageClientAuth)); err == nil {
+		resp.Body.Close()
+		t.Errorf("certificate from another CA accepted with status %d", resp.StatusCode)
+	}
+	if resp, err := post(nil, nil); err == nil {
+		resp.Body.Close()
+		t.Errorf("request without a client certificate accepted with status %d", resp.StatusCode)
+	}
+}
+
+func TestClientCAWithoutServerCertificate(t *testing.T) {
+	setConfig(t, "server.tls.enabled", false)
+	setConfig(t, "server.tls.certFile", "")
+	setConfig(t, "server.tls.keyFile", "")
+	setConfig(t, "server.tls.clientCAFile", "ca.pem")
+	if _, _, err := serverTLSConfig(); err == nil {
+		t.Error("client CA without a server certificate accepted")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// responseTemplate reshapes decisions before they are returned, when
+// `evaluate.responseTemplate` is configured.
+var responseTemplate *template.Template
+
+// loadResponseTemplate parses the configured response template once at
+// startup. The template receives the decision object, with fields allow,
+// result and decisionId, and may use toJSON to encode values.
+func loadResponseTemplate() error {
+	src := viper.GetString("evaluate.responseTemplate")
+	if src == "" {
+		return nil
+	}
+
+	tmpl, err := template.New("response").Funcs(template.FuncMap{"toJSON": toJSON}).Parse(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse response template: %w", err)
+	}
+	responseTemplate = tmpl
+	return nil
+}
+
+// renderDecision applies the response template to decision.
+func renderDecision(decision map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := responseTemplate.Execute(&buf, decision); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// useResponseTemplate loads src as `evaluate.responseTemplate` for the test.
+func useResponseTemplate(t *testing.T, src string) {
+	t.Helper()
+	previous := responseTemplate
+	t.Cleanup(func() { responseTemplate = previous })
+	setConfig(t, "evaluate.responseTemplate", src)
+	if err := loadResponseTemplate(); err != nil {
+		t.Fatalf("failed to load response template: %v", err)
+	}
+}
+
+func TestResponseTemplateRenamesFields(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	useResponseTemplate(t, `{"permitted": {{toJSON .allow}}, "id": {{toJSON .decisionId}}}`)
+	setConfig(t, "evaluate.responseContentType", "application/vnd.acme.decision+json")
+
+	for input, want := range map[string]bool{`{"role": "admin"}`: true, `{"role": "guest"}`: false} {
+		rec := postEvaluate("/evaluate", input)
+		if got := rec.Header().Get("Content-Type"); got != "application/vnd.acme.decision+json" {
+			t.Errorf("Content-Type = %q, want the configured type", got)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("rendered response is not JSON: %v: %s", err, rec.Body)
+		}
+		if len(body) != 2 || body["permitted"] != want || body["id"] != rec.Header().Get("X-Decision-ID") {
+			t.Errorf("%s rendered as %v, want permitted=%v and the decision id", input, body, want)
+		}
+	}
+}
+
+func TestResponseTemplateKeepsStatus(t *testing.T) {
+	loadTestPolicy(t, stringLoader(accessPolicy))
+	useResponseTemplate(t, `{{if .allow}}yes{{else}}no{{end}}`)
+
+	rec := postEvaluate("/evaluate", `{"role": "guest"}`)
+	if rec.Code != http.StatusForbidden || rec.Body.String() != "no" {
+		t.Errorf("got %d %q, want 403 no", rec.Code, rec.Body)
+	}
+}
+
+func TestLoadResponseTemplateRejectsInvalidTemplate(t *testing.T) {
+	previous := responseTemplate
+	t.Cleanup(func() { responseTemplate = previous })
+	setConfig(t, "evaluate.responseTemplate", "{{.allow")
+	if err := loadResponseTemplate(); err == nil {
+		t.Error("invalid template accepted")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/spf13/viper"
+
+	// Registers the Wasm evaluation engine used by rego.Target("wasm").
+	_ "github.com/open-policy-agent/opa/features/wasm"
+)
+
+// usesWasm reports whether the allow query is evaluated with Wasm, from
+// `policy.target`: "rego" (the default topdown interpreter) or "wasm",
+// which compiles the policy to WebAssembly once per load and is faster for
+// high-throughput deployments. Auxiliary queries such as reasons and scopes
+// always use the interpreter.
+func usesWasm() (bool, error) {
+	switch target := viper.GetString("policy.target"); target {
+	case "rego":
+		return false, nil
+	case "wasm":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown policy.target %q", target)
+	}
+}
+
+// prepareWasmQuery compiles the allow query to Wasm. Unlike the interpreter,
+// the Wasm engine copies data into the module when the query is prepared
+// and never reads the store again, so it is prepared against a snapshot of
+// dataStore with bundleData in place, and again after every later data
+// write by refreshWasmQuery.
+func prepareWasmQuery(ctx context.Context, modules map[string]string, bundleData map[string]interface{}) (rego.PreparedEvalQuery, error) {
+	data, err := dataSnapshot(ctx, bundleData)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("failed to snapshot policy data: %w", err)
+	}
+	return prepareQuery(ctx, allowQuery(), modules, rego.Target("wasm"), rego.Store(inmem.NewFromObject(data)))
+}
+
+// refreshWasmQuery prepares the current policy's Wasm allow query again
+// after data was written outside a policy load, such as by the DynamoDB
+// mirror, so Wasm decisions see the same data as the interpreter. It does
+// nothing under the interpreter. On failure the previous query, with the
+// previous data, keeps serving.
+func refreshWasmQuery(ctx context.Context) error {
+	if wasm, err := usesWasm(); err != nil || !wasm {
+		return err
+	}
+
+	policyLoads.Lock()
+	defer policyLoads.Unlock()
+
+	current := policies.Get()
+	if current == nil {
+		return nil
+	}
+	query, err := prepareWasmQuery(ctx, current.modules, current.data)
+	if err != nil {
+		return fmt.Errorf("failed to prepare wasm query: %w", err)
+	}
+	updated := *current
+	updated.query = &query
+	policies.Set(&updated)
+	retainRevision(&updated)
+	return nil
+}
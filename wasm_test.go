@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// wasmPolicy decides from both bundle data and mirrored reference data.
+const wasmPolicy = `package api.access
+
+import rego.v1
+
+default allow := false
+
+allow if data.roles[input.user] == "admin"
+
+allow if {
+	data.reference.roles[input.user].role == "reader"
+	input.action in {"read", "list"}
+}
+`
+
+var wasmInputs = []map[string]interface{}{
+	{"user": "alice"},
+	{"user": "bob", "action": "read"},
+	{"user": "bob", "action": "write"},
+	{"user": "carol", "action": "list"},
+	{"user": "mallory"},
+	{},
+}
+
+// targetDecisions loads wasmPolicy for target and decides every input in
+// wasmInputs, before and after reference data is mirrored in.
+func targetDecisions(t *testing.T, target string) (before, after []bool) {
+	t.Helper()
+	setConfig(t, "policy.target", target)
+	loadTestPolicy(t, bundleStub{
+		modules: map[string]string{"access.rego": wasmPolicy},
+		data:    map[string]interface{}{"roles": map[string]interface{}{"alice": "admin"}},
+	})
+	decide := func() []bool {
+		var out []bool
+		for _, input := range wasmInputs {
+			decision, err := Evaluate(context.Background(), policies.Get().query, input)
+			if err != nil {
+				t.Fatalf("%s: Evaluate(%v) = %v", target, input, err)
+			}
+			allow, _ := decision.Allow()
+			out = append(out, allow)
+		}
+		return out
+	}
+	before = decide()
+
+	l := &dynamoDataLoader{
+		client:       &mockScanner{pages: [][]map[string]types.AttributeValue{{roleItem("bob", "reader"), roleItem("carol", "reader")}}},
+		table:        "roles",
+		keyAttribute: "id",
+		path:         storage.Path{"reference", "roles"},
+	}
+	t.Cleanup(func() {
+		storage.Txn(context.Background(), dataStore, storage.WriteParams, func(txn storage.Transaction) error {
+			return dataStore.Write(context.Background(), txn, storage.RemoveOp, storage.Path{"reference"}, nil)
+		})
+	})
+	if err := l.refresh(context.Background()); err != nil {
+		t.Fatalf("%s: refresh failed: %v", target, err)
+	}
+	return before, decide()
+}
+
+func TestWasmTargetMatchesInterpreter(t *testing.T) {
+	// Each target runs in its own subtest so its data is cleared in between.
+	decisions := map[string][2][]bool{}
+	for _, target := range []string{"rego", "wasm"} {
+		t.Run(target, func(t *testing.T) {
+			before, after := targetDecisions(t, target)
+			decisions[target] = [2][]bool{before, after}
+		})
+	}
+	interp, wasm := decisions["rego"], decisions["wasm"]
+	if len(interp[0]) == 0 || len(wasm[0]) == 0 {
+		t.Fatal("a target failed to decide")
+	}
+	for i, input := range wasmInputs {
+		if wasm[0][i] != interp[0][i] || wasm[1][i] != interp[1][i] {
+			t.Errorf("%v: wasm decided %v then %v, interpreter %v then %v", input, wasm[0][i], wasm[1][i], interp[0][i], interp[1][i])
+		}
+	}
+	if interp[0][1] || !interp[1][1] {
+		t.Errorf("mirrored data did not change bob's read decision: %v then %v", interp[0][1], interp[1][1])
+	}
+}
+
+func BenchmarkEvaluateTargets(b *testing.B) {
+	input := map[string]interface{}{"role": "reader", "action": "read"}
+	for _, target := range []string{"rego", "wasm"} {
+		b.Run(target, func(b *testing.B) {
+			modules := map[string]string{"access.rego": accessPolicy}
+			query, err := prepareQuery(context.Background(), allowQuery(), modules)
+			if target == "wasm" {
+				query, err = prepareWasmQuery(context.Background(), modules, nil)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Evaluate(context.Background(), &query, input); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}